@@ -0,0 +1,92 @@
+package main
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/goccha/gormsource/dialects/mysql"
+	"github.com/goccha/gormsource/dialects/postgresql"
+	"github.com/goccha/gormsource/dialects/sqlite3"
+	"github.com/goccha/gormsource/dialects/sqlserver"
+	"github.com/goccha/gormsource/pkg/dialects"
+)
+
+type dialect struct {
+	name string
+	// builder reads the dialect Environment with prefix.
+	builder func(prefix string) dialects.Builder
+	// env returns the dialect Environment with prefix, whose string fields are the keys.
+	env func(prefix string) interface{}
+}
+
+var dialectList = []dialect{
+	{
+		name: "mysql",
+		builder: func(prefix string) dialects.Builder {
+			return mysql.New(mysql.Env(mysql.EnvWithPrefix(prefix)))
+		},
+		env: func(prefix string) interface{} {
+			return mysql.EnvWithPrefix(prefix)
+		},
+	},
+	{
+		name: "postgres",
+		builder: func(prefix string) dialects.Builder {
+			return postgresql.New(postgresql.Env(postgresql.EnvWithPrefix(prefix)))
+		},
+		env: func(prefix string) interface{} {
+			return postgresql.EnvWithPrefix(prefix)
+		},
+	},
+	{
+		name: "sqlite3",
+		builder: func(prefix string) dialects.Builder {
+			return sqlite3.New(sqlite3.Env(sqlite3.EnvWithPrefix(prefix)))
+		},
+		env: func(prefix string) interface{} {
+			return sqlite3.EnvWithPrefix(prefix)
+		},
+	},
+	{
+		name: "sqlserver",
+		builder: func(prefix string) dialects.Builder {
+			return sqlserver.New(sqlserver.Env(sqlserver.EnvWithPrefix(prefix)))
+		},
+		env: func(prefix string) interface{} {
+			return sqlserver.EnvWithPrefix(prefix)
+		},
+	},
+}
+
+var aliases = map[string]string{
+	"postgresql": "postgres",
+	"sqlite":     "sqlite3",
+	"mssql":      "sqlserver",
+}
+
+func lookupDialect(name string) (dialect, bool) {
+	name = strings.ToLower(name)
+	if v, ok := aliases[name]; ok {
+		name = v
+	}
+	for _, d := range dialectList {
+		if d.name == name {
+			return d, true
+		}
+	}
+	return dialect{}, false
+}
+
+// envKeys returns the values of the string fields of env, which are the names of the variables.
+func envKeys(env interface{}) []string {
+	v := reflect.Indirect(reflect.ValueOf(env))
+	keys := make([]string, 0, v.NumField())
+	for i := 0; i < v.NumField(); i++ {
+		if f := v.Field(i); f.Kind() == reflect.String && f.String() != "" {
+			keys = append(keys, f.String())
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/migrations"
+)
+
+const usage = `Usage: gormsource [flags] <command> [flags] [args]
+
+Commands:
+  ping          connect to the primary and the replicas and report their latencies
+  dsn           print the redacted DSN of the primary and the replicas
+  wait          block until the primary and the replicas are reachable
+  migrate       apply, revert or list the migrations: migrate [-dir dir] up | down [-n n] | status
+  env-template  list the environment variables of the datasource and the dialect
+
+The datasource is read from the same variables as datasources.Env, e.g. DB_HOST and DB_REPLICA_0_HOST,
+and the dialect from its Environment, e.g. MYSQL_CHARSET.
+
+Flags:
+`
+
+type cli struct {
+	stdout  io.Writer
+	stderr  io.Writer
+	dialect string
+	prefix  string
+	logger  *slog.Logger
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	code := run(ctx, os.Args[1:], os.Stdout, os.Stderr)
+	stop()
+	os.Exit(code)
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) int {
+	c := &cli{stdout: stdout, stderr: stderr}
+	fs := flag.NewFlagSet("gormsource", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.StringVar(&c.dialect, "dialect", os.Getenv("DB_DIALECT"),
+		"mysql, postgres, sqlite3 or sqlserver, defaults to DB_DIALECT. Without it the scheme of DB_CONNECT_URL is used")
+	fs.StringVar(&c.prefix, "prefix", "", "read <prefix>_DB_* and <prefix>_<DIALECT>_* instead of DB_* and <DIALECT>_*")
+	verbose := fs.Bool("v", false, "log connection attempts")
+	fs.Usage = func() {
+		_, _ = fmt.Fprint(stderr, usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return 2
+	}
+	level := slog.LevelWarn
+	if *verbose {
+		level = slog.LevelDebug
+	}
+	c.logger = slog.New(slog.NewTextHandler(stderr, &slog.HandlerOptions{Level: level}))
+	logging.SetSlog(c.logger)
+	defer logging.SetLogger(nil)
+
+	var err error
+	switch cmd, args := fs.Arg(0), fs.Args()[1:]; cmd {
+	case "ping":
+		err = c.ping(ctx, args)
+	case "dsn":
+		err = c.dsn()
+	case "wait":
+		err = c.wait(ctx, args)
+	case "migrate":
+		err = c.migrate(ctx, args)
+	case "env-template":
+		err = c.envTemplate()
+	default:
+		_, _ = fmt.Fprintf(stderr, "unknown command: %s\n", cmd)
+		fs.Usage()
+		return 2
+	}
+	if err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			_, _ = fmt.Fprintln(stderr, err)
+		}
+		return 1
+	}
+	return 0
+}
+
+func (c *cli) env() *datasources.Env {
+	if c.prefix == "" {
+		return &datasources.Env{}
+	}
+	return datasources.EnvWithPrefix(dialects.EnvKey(c.prefix, "DB"))
+}
+
+// configs returns the Config of the primary followed by those of the replicas.
+func (c *cli) configs() ([]*datasources.Config, error) {
+	env := c.env()
+	var primary *datasources.Config
+	if c.dialect == "" {
+		config, err := env.Load()
+		if err != nil {
+			return nil, fmt.Errorf("-dialect is not set: %w", err)
+		}
+		primary = config
+	} else {
+		d, ok := lookupDialect(c.dialect)
+		if !ok {
+			return nil, fmt.Errorf("unknown dialect: %s", c.dialect)
+		}
+		config, err := env.BuildStrict(d.builder(c.prefix))
+		if err != nil {
+			return nil, err
+		}
+		primary = config
+	}
+	configs := append([]*datasources.Config{primary}, env.BuildReplicas(primary)...)
+	for _, config := range configs {
+		config.Log.Slog = c.logger
+	}
+	return configs, nil
+}
+
+func name(i int) string {
+	if i == 0 {
+		return "primary"
+	}
+	return "replica_" + strconv.Itoa(i-1)
+}
+
+func (c *cli) ping(ctx context.Context, args []string) error {
+	fs := c.flags("ping")
+	timeout := fs.Duration("timeout", 5*time.Second, "timeout of each connection")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	configs, err := c.configs()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+	down := 0
+	for i, config := range configs {
+		config.Retry = &dialects.RetryPolicy{MaxAttempts: 1}
+		h := c.check(ctx, *timeout, name(i), config)
+		if h.Status != datasources.StatusUp {
+			down++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", h.Name, h.Status, h.Latency, h.Error)
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if down > 0 {
+		return fmt.Errorf("%d of %d connections are down", down, len(configs))
+	}
+	return nil
+}
+
+func (c *cli) check(ctx context.Context, timeout time.Duration, name string, config *datasources.Config) datasources.ConnectionHealth {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	ds, err := datasources.NewDataSourceE(ctx, config)
+	if err != nil {
+		return datasources.ConnectionHealth{Name: name, Status: datasources.StatusDown, Error: err.Error()}
+	}
+	defer datasources.Close(ds.GetConnection())
+	return datasources.CheckDB(ctx, name, ds.GetConnection())
+}
+
+func (c *cli) dsn() error {
+	configs, err := c.configs()
+	if err != nil {
+		return err
+	}
+	for i, config := range configs {
+		_, _ = fmt.Fprintf(c.stdout, "%s\t%s\n", name(i), config)
+	}
+	return nil
+}
+
+// wait retries with the connection RetryPolicy, which gives up early on permanent errors such as a rejected password.
+func (c *cli) wait(ctx context.Context, args []string) error {
+	fs := c.flags("wait")
+	timeout := fs.Duration("timeout", time.Minute, "give up after this duration")
+	interval := fs.Duration("interval", time.Second, "interval between attempts")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	configs, err := c.configs()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, *timeout)
+	defer cancel()
+	start := time.Now()
+	for i, config := range configs {
+		config.Retry = &dialects.RetryPolicy{
+			MaxElapsedTime:  *timeout,
+			InitialInterval: *interval,
+			MaxInterval:     *interval,
+			Multiplier:      1,
+		}
+		ds, err := datasources.NewDataSourceE(ctx, config)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name(i), err)
+		}
+		datasources.Close(ds.GetConnection())
+		_, _ = fmt.Fprintf(c.stdout, "%s\tup\t%s\n", name(i), time.Since(start).Round(time.Millisecond))
+	}
+	return nil
+}
+
+func (c *cli) migrate(ctx context.Context, args []string) error {
+	fs := c.flags("migrate")
+	dir := fs.String("dir", "migrations", "directory of the <version>_<name>.up.sql and .down.sql files")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return errors.New("migrate up | down [-n n] | status")
+	}
+	configs, err := c.configs()
+	if err != nil {
+		return err
+	}
+	primary := configs[0]
+	ds, err := datasources.NewDataSourceE(ctx, primary)
+	if err != nil {
+		return err
+	}
+	defer datasources.Close(ds.GetConnection())
+	m, err := migrations.New(ds.GetConnection(), primary.Builder(), os.DirFS(*dir))
+	if err != nil {
+		return err
+	}
+	switch fs.Arg(0) {
+	case "up":
+		applied, err := m.Up(ctx)
+		for _, mig := range applied {
+			_, _ = fmt.Fprintf(c.stdout, "applied\t%s\n", mig)
+		}
+		return err
+	case "down":
+		down := c.flags("down")
+		n := down.Int("n", 1, "number of migrations to revert")
+		if err = down.Parse(fs.Args()[1:]); err != nil {
+			return err
+		}
+		reverted, err := m.Down(ctx, *n)
+		for _, mig := range reverted {
+			_, _ = fmt.Fprintf(c.stdout, "reverted\t%s\n", mig)
+		}
+		return err
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(c.stdout, 0, 4, 2, ' ', 0)
+		for _, s := range statuses {
+			state, at := "pending", ""
+			if s.Applied {
+				state, at = "applied", s.AppliedAt.Format(time.RFC3339)
+				if s.Modified {
+					state = "modified"
+				}
+			}
+			_, _ = fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", s.Version, s.Name, state, at)
+		}
+		return w.Flush()
+	}
+	return fmt.Errorf("unknown migrate command: %s", fs.Arg(0))
+}
+
+func (c *cli) envTemplate() error {
+	selected := dialectList
+	if c.dialect != "" {
+		d, ok := lookupDialect(c.dialect)
+		if !ok {
+			return fmt.Errorf("unknown dialect: %s", c.dialect)
+		}
+		selected = []dialect{d}
+	}
+	env := c.env()
+	_, _ = fmt.Fprintln(c.stdout, "# datasource")
+	for _, k := range env.Keys() {
+		_, _ = fmt.Fprintf(c.stdout, "%s=\n", k)
+	}
+	_, _ = fmt.Fprintln(c.stdout, "# replicas are numbered from 0 and inherit the unset values of the primary")
+	for _, k := range env.Replica(0).Keys() {
+		_, _ = fmt.Fprintf(c.stdout, "# %s=\n", k)
+	}
+	for _, d := range selected {
+		_, _ = fmt.Fprintf(c.stdout, "# %s\n", d.name)
+		for _, k := range envKeys(d.env(c.prefix)) {
+			_, _ = fmt.Fprintf(c.stdout, "%s=\n", k)
+		}
+	}
+	return nil
+}
+
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.stderr)
+	return fs
+}
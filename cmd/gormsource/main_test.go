@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func exec(t *testing.T, args ...string) (string, int) {
+	t.Helper()
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	code := run(context.Background(), args, stdout, stderr)
+	if code != 0 {
+		t.Logf("%s", stderr)
+	}
+	return stdout.String(), code
+}
+
+func TestDSN(t *testing.T) {
+	t.Setenv("APP_DB_HOST", "db.internal")
+	t.Setenv("APP_DB_USER", "app")
+	t.Setenv("APP_DB_PASSWORD", "secret")
+	t.Setenv("APP_DB_SCHEMA", "app")
+	t.Setenv("APP_DB_REPLICA_0_HOST", "replica.internal")
+	t.Setenv("APP_MYSQL_CHARSET", "utf8mb4")
+	actual, code := exec(t, "-dialect", "mysql", "-prefix", "APP", "dsn")
+	if code != 0 {
+		t.Fatalf("expected=0, actual=%d", code)
+	}
+	expected := "primary\tapp:xxxxx@tcp(db.internal:3306)/app?charset=utf8mb4\n" +
+		"replica_0\tapp:xxxxx@tcp(replica.internal:3306)/app?charset=utf8mb4\n"
+	if expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
+
+func TestEnvTemplate(t *testing.T) {
+	actual, code := exec(t, "-dialect", "postgresql", "env-template")
+	if code != 0 {
+		t.Fatalf("expected=0, actual=%d", code)
+	}
+	for _, expected := range []string{"\nDB_HOST=\n", "\nGORM_LOG_MODE=\n", "\n# DB_REPLICA_0_HOST=\n", "\n# postgres\n", "\nPOSTGRES_SSL_MODE=\n"} {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected=%q, actual=%s", expected, actual)
+		}
+	}
+	if strings.Contains(actual, "MYSQL_") {
+		t.Errorf("expected=postgres only, actual=%s", actual)
+	}
+	if actual, code = exec(t, "-dialect", "mssql", "env-template"); code != 0 || !strings.Contains(actual, "\nSQLSERVER_ENCRYPT=\n") {
+		t.Errorf("expected=SQLSERVER_ENCRYPT, actual=%s", actual)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SQLITE_PATH", filepath.Join(dir, "app.db"))
+	migrations := filepath.Join(dir, "migrations")
+	if err := os.Mkdir(migrations, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{
+		"1_create_users.up.sql":   "CREATE TABLE users (id integer primary key);",
+		"1_create_users.down.sql": "DROP TABLE users;",
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(migrations, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if actual, code := exec(t, "-dialect", "sqlite3", "ping"); code != 0 || !strings.HasPrefix(actual, "primary  up") {
+		t.Errorf("expected=primary  up, actual=%s (%d)", actual, code)
+	}
+	if actual, _ := exec(t, "-dialect", "sqlite3", "migrate", "-dir", migrations, "up"); actual != "applied\t1_create_users\n" {
+		t.Errorf("expected=applied\t1_create_users, actual=%s", actual)
+	}
+	actual, _ := exec(t, "-dialect", "sqlite3", "migrate", "-dir", migrations, "status")
+	if !strings.HasPrefix(actual, "1  create_users  applied") {
+		t.Errorf("expected=1  create_users  applied, actual=%s", actual)
+	}
+	if actual, _ = exec(t, "-dialect", "sqlite3", "migrate", "-dir", migrations, "down", "-n", "1"); actual != "reverted\t1_create_users\n" {
+		t.Errorf("expected=reverted\t1_create_users, actual=%s", actual)
+	}
+}
@@ -1,7 +1,11 @@
 package mysql
 
 import (
+	"context"
+	"database/sql"
+	sqldriver "database/sql/driver"
 	"errors"
+	"fmt"
 	driver "github.com/go-sql-driver/mysql"
 	"github.com/goccha/envar"
 	"github.com/goccha/gormsource/pkg/dialects"
@@ -9,6 +13,7 @@ import (
 	"gorm.io/gorm"
 	"strconv"
 	"strings"
+	"time"
 )
 
 const (
@@ -16,6 +21,28 @@ const (
 	NotAvailableLock = 3572
 )
 
+var authErrors = []uint16{
+	1045, // ER_ACCESS_DENIED_ERROR
+	1698, // ER_ACCESS_DENIED_NO_PASSWORD_ERROR
+}
+
+var permanentErrors = []uint16{
+	1044, // ER_DBACCESS_DENIED_ERROR
+	1045, // ER_ACCESS_DENIED_ERROR
+	1049, // ER_BAD_DB_ERROR
+	1251, // ER_NOT_SUPPORTED_AUTH_MODE
+	1698, // ER_ACCESS_DENIED_NO_PASSWORD_ERROR
+}
+
+func init() {
+	dialects.Register(func(params map[string]string) (dialects.Builder, error) {
+		return FromParams(params)
+	}, "mysql")
+	dialects.RegisterEnv(func(prefix string) dialects.Option {
+		return Env(EnvWithPrefix(prefix))
+	}, "mysql")
+}
+
 func New(options ...dialects.Option) *Builder {
 	b := &Builder{}
 	for _, opt := range options {
@@ -53,12 +80,16 @@ type Builder struct {
 	DontSupportRenameIndex    bool
 	DontSupportRenameColumn   bool
 	Extension                 dialects.Extension
+	Retry                     *dialects.RetryPolicy
 }
 
 func (b *Builder) Name() string {
 	return "mysql"
 }
 func (b *Builder) Put(k string, v string) *Builder {
+	if b.SystemVariables == nil {
+		b.SystemVariables = make(map[string]string)
+	}
 	b.SystemVariables[k] = v
 	return b
 }
@@ -184,16 +215,28 @@ func (b *Builder) BuildString(user, password, host string, port int, dbname stri
 	return buf.String()
 }
 
+func (b *Builder) Redacted(user, password, host string, port int, dbname string) string {
+	return b.BuildString(user, dialects.Mask(password), host, port, dbname)
+}
+
 func (b *Builder) Build(user, password, host string, port int, dbname string) gorm.Dialector {
+	d, err := b.BuildE(context.Background(), user, password, host, port, dbname)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (b *Builder) BuildE(ctx context.Context, user, password, host string, port int, dbname string) (gorm.Dialector, error) {
 	dsn := b.BuildString(user, password, host, port, dbname)
 	if b.Extension != nil {
-		if db, err := dialects.Connect(b.Name(), dsn, b.Extension); err != nil {
-			panic(err)
+		if db, err := dialects.ConnectContext(ctx, b.Name(), dsn, b.Extension, b.Retry.WithPermanent(b.IsPermanent)); err != nil {
+			return nil, err
 		} else {
 			return mysql.New(mysql.Config{
 				DSN:  dsn,
 				Conn: db,
-			})
+			}), nil
 		}
 	}
 	return mysql.New(mysql.Config{
@@ -204,6 +247,22 @@ func (b *Builder) Build(user, password, host string, port int, dbname string) go
 		DisableDatetimePrecision:  b.DisableDatetimePrecision,
 		DontSupportRenameIndex:    b.DontSupportRenameIndex,
 		DontSupportRenameColumn:   b.DontSupportRenameColumn,
+	}), nil
+}
+
+func (b *Builder) Driver() sqldriver.Driver {
+	return &driver.MySQLDriver{}
+}
+
+func (b *Builder) BuildConn(dsn string, conn *sql.DB) gorm.Dialector {
+	return mysql.New(mysql.Config{
+		DSN:                       dsn,
+		Conn:                      conn,
+		SkipInitializeWithVersion: b.SkipInitializeWithVersion,
+		DefaultStringSize:         b.DefaultStringSize,
+		DisableDatetimePrecision:  b.DisableDatetimePrecision,
+		DontSupportRenameIndex:    b.DontSupportRenameIndex,
+		DontSupportRenameColumn:   b.DontSupportRenameColumn,
 	})
 }
 
@@ -215,6 +274,182 @@ func (b *Builder) IsNotAvailableLock(err error) bool {
 	return false
 }
 
+func (b *Builder) IsAuthFailure(err error) bool {
+	var v *driver.MySQLError
+	if errors.As(err, &v) {
+		for _, n := range authErrors {
+			if v.Number == n {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// TimeoutStatements uses max_execution_time, which only limits SELECT, and innodb_lock_wait_timeout in whole seconds.
+func (b *Builder) TimeoutStatements(statement, lock time.Duration) []string {
+	stmts := make([]string, 0, 2)
+	if statement > 0 {
+		stmts = append(stmts, fmt.Sprintf("SET SESSION max_execution_time = %d", statement.Milliseconds()))
+	}
+	if lock > 0 {
+		stmts = append(stmts, fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", seconds(lock)))
+	}
+	return stmts
+}
+
+// LocalTimeoutStatements sets session variables because MySQL has no transaction scoped ones, so restore is required.
+func (b *Builder) LocalTimeoutStatements(statement, lock, defaultStatement, defaultLock time.Duration) (set, restore []string) {
+	set = b.TimeoutStatements(statement, lock)
+	if statement > 0 {
+		if defaultStatement > 0 {
+			restore = append(restore, fmt.Sprintf("SET SESSION max_execution_time = %d", defaultStatement.Milliseconds()))
+		} else {
+			restore = append(restore, "SET SESSION max_execution_time = DEFAULT")
+		}
+	}
+	if lock > 0 {
+		if defaultLock > 0 {
+			restore = append(restore, fmt.Sprintf("SET SESSION innodb_lock_wait_timeout = %d", seconds(defaultLock)))
+		} else {
+			restore = append(restore, "SET SESSION innodb_lock_wait_timeout = DEFAULT")
+		}
+	}
+	return set, restore
+}
+
+func seconds(d time.Duration) int64 {
+	s := int64((d + time.Second - 1) / time.Second)
+	if s < 1 {
+		s = 1
+	}
+	return s
+}
+
+// LockStatements use a named lock, which waits without a timeout.
+func (b *Builder) LockStatements(name string) (lock, unlock string, transaction bool) {
+	name = quote(name)
+	return "SELECT GET_LOCK(" + name + ", -1)", "SELECT RELEASE_LOCK(" + name + ")", false
+}
+
+// LockQuery is true because GET_LOCK returns 0 or NULL instead of failing when the lock is not acquired.
+func (b *Builder) LockQuery() bool {
+	return true
+}
+
+// TransactionalDDL is false because DDL statements commit implicitly.
+func (b *Builder) TransactionalDDL() bool {
+	return false
+}
+
+func quote(s string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", "''").Replace(s) + "'"
+}
+
+func (b *Builder) Validate() error {
+	errs := dialects.MultiError{}
+	for _, v := range [][2]string{{"readTimeout", b.ReadTimeout}, {"timeout", b.Timeout}, {"writeTimeout", b.WriteTimeout}} {
+		if v[1] == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(v[1]); err != nil {
+			errs.Addf("%s: invalid duration %q", v[0], v[1])
+		}
+	}
+	if b.Loc != "" {
+		if _, err := time.LoadLocation(b.Loc); err != nil {
+			errs.Addf("loc: %v", err)
+		}
+	}
+	return errs.Err()
+}
+
+func (b *Builder) IsPermanent(err error) bool {
+	var v *driver.MySQLError
+	if errors.As(err, &v) {
+		for _, n := range permanentErrors {
+			if v.Number == n {
+				return true
+			}
+		}
+		return false
+	}
+	return errors.Is(err, driver.ErrNativePassword) ||
+		errors.Is(err, driver.ErrCleartextPassword) ||
+		errors.Is(err, driver.ErrOldPassword)
+}
+
+// FromParams creates a Builder from DSN parameter names such as charset, tls or parseTime.
+// Unknown names are treated as system variables.
+func FromParams(params map[string]string) (*Builder, error) {
+	b := New()
+	for k, v := range params {
+		var err error
+		switch k {
+		case "instanceName":
+			InstanceName(v)(b)
+		case "protocol":
+			Protocol(v)(b)
+		case "allowAllFiles":
+			err = parseBool(v, func(v bool) { AllowAllFiles(v)(b) })
+		case "allowCleartextPasswords":
+			err = parseBool(v, func(v bool) { AllowCleartextPasswords(v)(b) })
+		case "allowNativePasswords":
+			err = parseBool(v, func(v bool) { AllowNativePasswords(v)(b) })
+		case "allowOldPasswords":
+			err = parseBool(v, func(v bool) { AllowOldPasswords(v)(b) })
+		case "charset":
+			Charset(v)(b)
+		case "collation":
+			Collation(v)(b)
+		case "clientFoundRows":
+			err = parseBool(v, func(v bool) { ClientFoundRows(v)(b) })
+		case "columnsWithAlias":
+			err = parseBool(v, func(v bool) { ColumnsWithAlias(v)(b) })
+		case "interpolateParams":
+			err = parseBool(v, func(v bool) { InterpolateParams(v)(b) })
+		case "loc":
+			Loc(v)(b)
+		case "maxAllowedPacket":
+			var n int
+			if n, err = strconv.Atoi(v); err == nil {
+				MaxAllowedPacket(n)(b)
+			}
+		case "multiStatements":
+			err = parseBool(v, func(v bool) { MultiStatements(v)(b) })
+		case "parseTime":
+			err = parseBool(v, func(v bool) { ParseTime(v)(b) })
+		case "readTimeout":
+			ReadTimeout(v)(b)
+		case "rejectReadOnly":
+			err = parseBool(v, func(v bool) { RejectReadOnly(v)(b) })
+		case "serverPubKey":
+			ServerPubKey(v)(b)
+		case "timeout":
+			Timeout(v)(b)
+		case "tls":
+			Tls(v)(b)
+		case "writeTimeout":
+			WriteTimeout(v)(b)
+		default:
+			b.Put(k, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return b, nil
+}
+
+func parseBool(s string, f func(v bool)) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	f(v)
+	return nil
+}
+
 type Environment struct {
 	InstanceName              string
 	Protocol                  string
@@ -244,6 +479,74 @@ type Environment struct {
 	DontSupportRenameColumn   string
 }
 
+// EnvWithPrefix derives every key from prefix, e.g. BILLING_MYSQL_CHARSET for "BILLING".
+func EnvWithPrefix(prefix string) *Environment {
+	key := func(name string) string {
+		return dialects.EnvKey(prefix, "MYSQL_"+name)
+	}
+	return &Environment{
+		InstanceName:              key("INSTANCE_NAME"),
+		Protocol:                  key("PROTOCOL"),
+		AllowAllFiles:             key("ALLOW_ALL_FILES"),
+		AllowCleartextPasswords:   key("ALLOW_CLEARTEXT_PASSWORDS"),
+		AllowNativePasswords:      key("ALLOW_NATIVE_PASSWORDS"),
+		AllowOldPasswords:         key("ALLOW_OLD_PASSWORDS"),
+		Charset:                   key("CHARSET"),
+		Collation:                 key("COLLATION"),
+		ClientFoundRows:           key("CLIENT_FOUND_ROWS"),
+		ColumnsWithAlias:          key("COLUMNS_WITH_ALIAS"),
+		InterpolateParams:         key("INTERPOLATE_PARAMS"),
+		Loc:                       key("LOC"),
+		MaxAllowedPacket:          key("MAX_ALLOWED_PACKET"),
+		MultiStatements:           key("MULTI_STATEMENTS"),
+		ParseTime:                 key("PARSE_TIME"),
+		ReadTimeout:               key("READ_TIMEOUT"),
+		RejectReadOnly:            key("REJECT_READ_ONLY"),
+		ServerPubKey:              key("SERVER_PUB_KEY"),
+		Timeout:                   key("TIMEOUT"),
+		Tls:                       key("TLS"),
+		WriteTimeout:              key("WRITE_TIMEOUT"),
+		SkipInitializeWithVersion: key("SKIP_INITIALIZE_WITH_VERSION"),
+		DefaultStringSize:         key("DEFAULT_STRING_SIZE"),
+		DisableDatetimePrecision:  key("DISABLE_DATETIME_PRECISION"),
+		DontSupportRenameIndex:    key("DONT_SUPPORT_RENAME_INDEX"),
+		DontSupportRenameColumn:   key("DONT_SUPPORT_RENAME_COLUMN"),
+	}
+}
+
+// BuildStrict is Build that fails when a variable that is set cannot be parsed.
+func (env *Environment) BuildStrict(b *Builder) error {
+	errs := dialects.MultiError{}
+	errs.Add(env.Validate())
+	env.Build(b)
+	errs.Add(b.Validate())
+	return errs.Err()
+}
+
+func (env *Environment) Validate() error {
+	errs := dialects.MultiError{}
+	errs.CheckBool(env.AllowAllFiles)
+	errs.CheckBool(env.AllowCleartextPasswords)
+	errs.CheckBool(env.AllowNativePasswords)
+	errs.CheckBool(env.AllowOldPasswords)
+	errs.CheckBool(env.ClientFoundRows)
+	errs.CheckBool(env.ColumnsWithAlias)
+	errs.CheckBool(env.InterpolateParams)
+	errs.CheckInt(env.MaxAllowedPacket)
+	errs.CheckBool(env.MultiStatements)
+	errs.CheckBool(env.ParseTime)
+	errs.CheckDuration(env.ReadTimeout)
+	errs.CheckBool(env.RejectReadOnly)
+	errs.CheckDuration(env.Timeout)
+	errs.CheckDuration(env.WriteTimeout)
+	errs.CheckBool(env.SkipInitializeWithVersion)
+	errs.CheckUint(env.DefaultStringSize)
+	errs.CheckBool(env.DisableDatetimePrecision)
+	errs.CheckBool(env.DontSupportRenameIndex)
+	errs.CheckBool(env.DontSupportRenameColumn)
+	return errs.Err()
+}
+
 func (env *Environment) Build(b *Builder) {
 	InstanceName(envar.String(env.InstanceName))(b)
 	Protocol(envar.String(env.Protocol))(b)
@@ -486,3 +789,10 @@ func Extension(f dialects.Extension) dialects.Option {
 		}
 	}
 }
+func Retry(policy *dialects.RetryPolicy) dialects.Option {
+	return func(b dialects.Builder) {
+		if policy != nil {
+			b.(*Builder).Retry = policy
+		}
+	}
+}
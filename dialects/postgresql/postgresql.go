@@ -1,12 +1,21 @@
 package postgresql
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
 	"errors"
+	"fmt"
 	"github.com/goccha/envar"
 	"github.com/goccha/gormsource/pkg/dialects"
 	"github.com/jackc/pgconn"
+	pgconnv5 "github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/stdlib"
 	"gorm.io/driver/postgres"
 	"gorm.io/gorm"
+	"hash/fnv"
+	"net"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -17,6 +26,26 @@ const (
 	NotAvailableLock = "55P03"
 )
 
+var authErrors = []string{
+	"28000", // invalid_authorization_specification
+	"28P01", // invalid_password
+}
+
+var permanentErrors = []string{
+	"28000", // invalid_authorization_specification
+	"28P01", // invalid_password
+	"3D000", // invalid_catalog_name
+}
+
+func init() {
+	dialects.Register(func(params map[string]string) (dialects.Builder, error) {
+		return FromParams(params)
+	}, "postgres", "postgresql", "pgx")
+	dialects.RegisterEnv(func(prefix string) dialects.Option {
+		return Env(EnvWithPrefix(prefix))
+	}, "postgres", "postgresql", "pgx")
+}
+
 func New(options ...dialects.Option) *Builder {
 	b := &Builder{}
 	for _, opt := range options {
@@ -33,13 +62,33 @@ type Builder struct {
 	SslKey                  string
 	SslRootCert             string
 	PreferSimpleProtocol    bool
-	Extension               dialects.Extension
+	ApplicationName         string
+	SearchPath              string
+	TimeZone                string
+	// StatementTimeout is sent as a startup parameter, so unlike datasources.Config it costs no statement.
+	StatementTimeout time.Duration
+	// Hosts are tried after the host of the Config in order, e.g. "standby-1" or "standby-2:5433".
+	// A host without a port uses that of the Config.
+	Hosts              []string
+	TargetSessionAttrs TargetSessionAttrs
+	// RuntimeParams are other parameters set for the session, e.g. {"work_mem": "64MB"}.
+	RuntimeParams map[string]string
+	Extension     dialects.Extension
+	Retry         *dialects.RetryPolicy
 }
 
 func (b *Builder) Name() string {
 	return "pgx"
 }
 
+func (b *Builder) Put(k string, v string) *Builder {
+	if b.RuntimeParams == nil {
+		b.RuntimeParams = make(map[string]string)
+	}
+	b.RuntimeParams[k] = v
+	return b
+}
+
 func (b *Builder) BuildDialector(url string) gorm.Dialector {
 	return postgres.Open(url)
 }
@@ -48,14 +97,11 @@ func (b *Builder) BuildString(user, password, host string, port int, dbname stri
 	buf := &strings.Builder{}
 	dialects.WriteString(buf, "user", user, "")
 	dialects.WriteString(buf, "password", password, " ")
-	if host != "" {
-		dialects.WriteString(buf, "host", host, " ")
-	}
-	if port > 0 {
-		dialects.WriteString(buf, "port", strconv.Itoa(port), " ")
-	} else {
-		dialects.WriteString(buf, "port", DefaultPort, " ")
+	hosts, ports := b.hosts(host, port)
+	if len(hosts) > 0 {
+		dialects.WriteString(buf, "host", strings.Join(hosts, ","), " ")
 	}
+	dialects.WriteString(buf, "port", strings.Join(ports, ","), " ")
 	dialects.WriteString(buf, "dbname", dbname, " ")
 	if b.SslMode != "" {
 		dialects.WriteString(buf, "sslmode", b.SslMode, " ")
@@ -76,34 +122,202 @@ func (b *Builder) BuildString(user, password, host string, port int, dbname stri
 	if b.SslRootCert != "" {
 		dialects.WriteString(buf, "sslrootcert", b.SslRootCert, " ")
 	}
+	if b.ApplicationName != "" {
+		dialects.WriteString(buf, "application_name", quote(b.ApplicationName), " ")
+	}
+	if b.SearchPath != "" {
+		dialects.WriteString(buf, "search_path", quote(b.SearchPath), " ")
+	}
+	if b.TimeZone != "" {
+		dialects.WriteString(buf, "TimeZone", quote(b.TimeZone), " ")
+	}
+	if b.StatementTimeout > 0 {
+		dialects.WriteString(buf, "statement_timeout", strconv.FormatInt(b.StatementTimeout.Milliseconds(), 10), " ")
+	}
+	if b.TargetSessionAttrs != "" {
+		dialects.WriteString(buf, "target_session_attrs", string(b.TargetSessionAttrs), " ")
+	}
+	if b.PreferSimpleProtocol {
+		// gorm ignores PreferSimpleProtocol for the *sql.DB of BuildConn, so pgx reads it from the DSN.
+		dialects.WriteString(buf, "default_query_exec_mode", "simple_protocol", " ")
+	}
+	keys := make([]string, 0, len(b.RuntimeParams))
+	for k := range b.RuntimeParams {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		dialects.WriteString(buf, k, quote(b.RuntimeParams[k]), " ")
+	}
 	return buf.String()
 }
 
+// hosts returns host, which may list several hosts separated by commas, followed by Hosts, and a port for each.
+// The ports collapse into one when they are all the same.
+func (b *Builder) hosts(host string, port int) (hosts, ports []string) {
+	defaultPort := DefaultPort
+	if port > 0 {
+		defaultPort = strconv.Itoa(port)
+	}
+	entries := make([]string, 0, 1+len(b.Hosts))
+	if host != "" {
+		entries = append(entries, strings.Split(host, ",")...)
+	}
+	entries = append(entries, b.Hosts...)
+	same := true
+	for _, e := range entries {
+		h, p, err := net.SplitHostPort(strings.TrimSpace(e))
+		if err != nil {
+			h, p = strings.Trim(strings.TrimSpace(e), "[]"), defaultPort
+		}
+		hosts = append(hosts, h)
+		ports = append(ports, p)
+		same = same && p == ports[0]
+	}
+	if len(ports) == 0 {
+		return hosts, []string{defaultPort}
+	}
+	if same {
+		return hosts, ports[:1]
+	}
+	return hosts, ports
+}
+
+// quote quotes a value that has spaces or quotes in the way of libpq.
+func quote(s string) string {
+	if s != "" && !strings.ContainsAny(s, ` '\`) {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
+
+func (b *Builder) Redacted(user, password, host string, port int, dbname string) string {
+	return b.BuildString(user, dialects.Mask(password), host, port, dbname)
+}
+
 func (b *Builder) Build(user, password, host string, port int, dbname string) gorm.Dialector {
+	d, err := b.BuildE(context.Background(), user, password, host, port, dbname)
+	if err != nil {
+		panic(err)
+	}
+	return d
+}
+
+func (b *Builder) BuildE(ctx context.Context, user, password, host string, port int, dbname string) (gorm.Dialector, error) {
 	dsn := b.BuildString(user, password, host, port, dbname)
 	if b.Extension != nil {
-		if db, err := dialects.Connect(b.Name(), dsn, b.Extension); err != nil {
-			return nil
+		if db, err := dialects.ConnectContext(ctx, b.Name(), dsn, b.Extension, b.Retry.WithPermanent(b.IsPermanent)); err != nil {
+			return nil, err
 		} else {
 			return postgres.New(postgres.Config{
 				DSN:                  dsn,
 				Conn:                 db,
 				PreferSimpleProtocol: b.PreferSimpleProtocol,
-			})
+			}), nil
 		}
 	}
 	return postgres.New(postgres.Config{
 		DSN:                  dsn,
 		PreferSimpleProtocol: b.PreferSimpleProtocol,
+	}), nil
+}
+
+func (b *Builder) Driver() driver.Driver {
+	return stdlib.GetDefaultDriver()
+}
+
+func (b *Builder) BuildConn(dsn string, conn *sql.DB) gorm.Dialector {
+	return postgres.New(postgres.Config{
+		DSN:                  dsn,
+		Conn:                 conn,
+		PreferSimpleProtocol: b.PreferSimpleProtocol,
 	})
 }
 
 func (b *Builder) IsNotAvailableLock(err error) bool {
+	return sqlState(err) == NotAvailableLock
+}
+
+func (b *Builder) IsAuthFailure(err error) bool {
+	code := sqlState(err)
+	for _, c := range authErrors {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+func (b *Builder) TimeoutStatements(statement, lock time.Duration) []string {
+	return timeoutStatements("SET", statement, lock)
+}
+
+// LocalTimeoutStatements uses SET LOCAL, which PostgreSQL reverts itself when the transaction ends.
+func (b *Builder) LocalTimeoutStatements(statement, lock, _, _ time.Duration) (set, restore []string) {
+	return timeoutStatements("SET LOCAL", statement, lock), nil
+}
+
+func timeoutStatements(command string, statement, lock time.Duration) []string {
+	stmts := make([]string, 0, 2)
+	if statement > 0 {
+		stmts = append(stmts, fmt.Sprintf("%s statement_timeout = %d", command, statement.Milliseconds()))
+	}
+	if lock > 0 {
+		stmts = append(stmts, fmt.Sprintf("%s lock_timeout = %d", command, lock.Milliseconds()))
+	}
+	return stmts
+}
+
+// LockStatements use a session level advisory lock keyed by the FNV-1a hash of name.
+func (b *Builder) LockStatements(name string) (lock, unlock string, transaction bool) {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(name))
+	key := int64(h.Sum64())
+	return fmt.Sprintf("SELECT pg_advisory_lock(%d)", key), fmt.Sprintf("SELECT pg_advisory_unlock(%d)", key), false
+}
+
+func (b *Builder) TransactionalDDL() bool {
+	return true
+}
+
+func (b *Builder) Validate() error {
+	errs := dialects.MultiError{}
+	switch SSLOption(b.SslMode) {
+	case "", SslDisable, SslAllow, SslPrefer, SslRequire, SslVerifyCa, SslVerifyFull:
+	default:
+		errs.Addf("unknown sslmode %q", b.SslMode)
+	}
+	switch b.TargetSessionAttrs {
+	case "", TargetAny, TargetReadWrite, TargetReadOnly, TargetPrimary, TargetStandby, TargetPreferStandby:
+	default:
+		errs.Addf("unknown target_session_attrs %q", b.TargetSessionAttrs)
+	}
+	if b.StatementTimeout < 0 {
+		errs.Addf("statement_timeout must not be negative")
+	}
+	return errs.Err()
+}
+
+func (b *Builder) IsPermanent(err error) bool {
+	code := sqlState(err)
+	for _, c := range permanentErrors {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+func sqlState(err error) string {
 	var v *pgconn.PgError
 	if errors.As(err, &v) {
-		return v.Code == NotAvailableLock
+		return v.Code
 	}
-	return false
+	var v5 *pgconnv5.PgError
+	if errors.As(err, &v5) {
+		return v5.Code
+	}
+	return ""
 }
 
 type SSLOption string
@@ -111,6 +325,10 @@ type SSLOption string
 const (
 	// disable - No SSL
 	SslDisable SSLOption = "disable"
+	// allow - First try a non-SSL connection; if that fails, try an SSL connection
+	SslAllow SSLOption = "allow"
+	// prefer - First try an SSL connection; if that fails, try a non-SSL connection
+	SslPrefer SSLOption = "prefer"
 	// require - Always SSL (skip verification)
 	SslRequire SSLOption = "require"
 	// verify-ca - Always SSL (verify that the certificate presented by the
@@ -121,6 +339,67 @@ const (
 	SslVerifyFull SSLOption = "verify-full"
 )
 
+type TargetSessionAttrs string
+
+const (
+	TargetAny       TargetSessionAttrs = "any"
+	TargetReadWrite TargetSessionAttrs = "read-write"
+	TargetReadOnly  TargetSessionAttrs = "read-only"
+	TargetPrimary   TargetSessionAttrs = "primary"
+	TargetStandby   TargetSessionAttrs = "standby"
+	// TargetPreferStandby falls back to any host when no standby is available.
+	TargetPreferStandby TargetSessionAttrs = "prefer-standby"
+)
+
+// FromParams creates a Builder from libpq parameter names such as sslmode or connect_timeout.
+// Other parameters are sent to the server as runtime params.
+func FromParams(params map[string]string) (*Builder, error) {
+	b := New()
+	for k, v := range params {
+		switch k {
+		case "sslmode":
+			SSLMode(SSLOption(v))(b)
+		case "fallback_application_name":
+			FallbackApplicationName(v)(b)
+		case "connect_timeout":
+			sec, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			ConnectTimeout(time.Duration(sec) * time.Second)(b)
+		case "sslcert":
+			SSLCert(v)(b)
+		case "sslkey":
+			SSLKey(v)(b)
+		case "sslrootcert":
+			SSLRootCert(v)(b)
+		case "prefer_simple_protocol":
+			simple, err := strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			PreferSimpleProtocol(&simple)(b)
+		case "application_name":
+			ApplicationName(v)(b)
+		case "search_path":
+			SearchPath(v)(b)
+		case "TimeZone", "timezone":
+			TimeZone(v)(b)
+		case "statement_timeout":
+			ms, err := strconv.Atoi(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			StatementTimeout(time.Duration(ms) * time.Millisecond)(b)
+		case "target_session_attrs":
+			TargetSession(TargetSessionAttrs(v))(b)
+		default:
+			b.Put(k, v)
+		}
+	}
+	return b, nil
+}
+
 type Environment struct {
 	SslMode                 string
 	FallbackApplicationName string
@@ -129,6 +408,60 @@ type Environment struct {
 	SslKey                  string
 	SslRootCert             string
 	PreferSimpleProtocol    string
+	ApplicationName         string
+	SearchPath              string
+	TimeZone                string
+	StatementTimeout        string
+	// Hosts holds comma separated hosts tried after the host of the Config, e.g. standby-1,standby-2:5433.
+	Hosts              string
+	TargetSessionAttrs string
+	// RuntimeParams holds comma separated name=value pairs, e.g. work_mem=64MB,lock_timeout=5s.
+	RuntimeParams string
+}
+
+// EnvWithPrefix derives every key from prefix, e.g. BILLING_POSTGRES_SSL_MODE for "BILLING".
+func EnvWithPrefix(prefix string) *Environment {
+	key := func(name string) string {
+		return dialects.EnvKey(prefix, "POSTGRES_"+name)
+	}
+	return &Environment{
+		SslMode:                 key("SSL_MODE"),
+		FallbackApplicationName: key("FALLBACK_APPLICATION_NAME"),
+		ConnectTimeout:          key("CONNECT_TIMEOUT"),
+		SslCert:                 key("SSL_CERT"),
+		SslKey:                  key("SSL_KEY"),
+		SslRootCert:             key("SSL_ROOT_CERT"),
+		PreferSimpleProtocol:    key("PREFER_SIMPLE_PROTOCOL"),
+		ApplicationName:         key("APPLICATION_NAME"),
+		SearchPath:              key("SEARCH_PATH"),
+		TimeZone:                key("TIME_ZONE"),
+		StatementTimeout:        key("STATEMENT_TIMEOUT"),
+		Hosts:                   key("HOSTS"),
+		TargetSessionAttrs:      key("TARGET_SESSION_ATTRS"),
+		RuntimeParams:           key("RUNTIME_PARAMS"),
+	}
+}
+
+// BuildStrict is Build that fails when a variable that is set cannot be parsed.
+func (env *Environment) BuildStrict(b *Builder) error {
+	errs := dialects.MultiError{}
+	errs.Add(env.Validate())
+	env.Build(b)
+	errs.Add(b.Validate())
+	return errs.Err()
+}
+
+func (env *Environment) Validate() error {
+	errs := dialects.MultiError{}
+	errs.CheckDuration(env.ConnectTimeout)
+	errs.CheckBool(env.PreferSimpleProtocol)
+	errs.CheckDuration(env.StatementTimeout)
+	if v := envar.String(env.RuntimeParams); v != "" {
+		if _, err := parseParams(v); err != nil {
+			errs.Addf("%s: %v", env.RuntimeParams, err)
+		}
+	}
+	return errs.Err()
 }
 
 func (env *Environment) Build(b *Builder) {
@@ -147,6 +480,34 @@ func (env *Environment) Build(b *Builder) {
 		v := envar.Bool(env.PreferSimpleProtocol)
 		PreferSimpleProtocol(&v)(b)
 	}
+	ApplicationName(envar.String(env.ApplicationName))(b)
+	SearchPath(envar.String(env.SearchPath))(b)
+	TimeZone(envar.String(env.TimeZone))(b)
+	StatementTimeout(envar.Duration(env.StatementTimeout))(b)
+	if v := envar.String(env.Hosts); v != "" {
+		Hosts(strings.Split(v, ",")...)(b)
+	}
+	TargetSession(TargetSessionAttrs(envar.String(env.TargetSessionAttrs)))(b)
+	if params, err := parseParams(envar.String(env.RuntimeParams)); err == nil {
+		for k, v := range params {
+			b.Put(k, v)
+		}
+	}
+}
+
+func parseParams(s string) (map[string]string, error) {
+	params := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid parameter %q", kv)
+		}
+		params[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return params, nil
 }
 
 func Env(env *Environment) dialects.Option {
@@ -206,6 +567,66 @@ func PreferSimpleProtocol(simple *bool) dialects.Option {
 		}
 	}
 }
+func ApplicationName(name string) dialects.Option {
+	return func(b dialects.Builder) {
+		if name != "" {
+			b.(*Builder).ApplicationName = name
+		}
+	}
+}
+
+// SearchPath sets the schemas to search, e.g. "app, public".
+func SearchPath(value string) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).SearchPath = value
+		}
+	}
+}
+
+func TimeZone(value string) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).TimeZone = value
+		}
+	}
+}
+
+func StatementTimeout(t time.Duration) dialects.Option {
+	return func(b dialects.Builder) {
+		if t > 0 {
+			b.(*Builder).StatementTimeout = t
+		}
+	}
+}
+
+// Hosts adds hosts to fail over to, each with an optional port, e.g. Hosts("standby-1", "standby-2:5433").
+func Hosts(hosts ...string) dialects.Option {
+	return func(b dialects.Builder) {
+		for _, h := range hosts {
+			if h = strings.TrimSpace(h); h != "" {
+				b.(*Builder).Hosts = append(b.(*Builder).Hosts, h)
+			}
+		}
+	}
+}
+
+// TargetSession chooses among the hosts by their role, e.g. TargetReadWrite to follow the primary after a failover.
+func TargetSession(value TargetSessionAttrs) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).TargetSessionAttrs = value
+		}
+	}
+}
+
+// RuntimeParam sets a parameter for the session, e.g. RuntimeParam("work_mem", "64MB").
+func RuntimeParam(name, value string) dialects.Option {
+	return func(b dialects.Builder) {
+		b.(*Builder).Put(name, value)
+	}
+}
+
 func Extension(f dialects.Extension) dialects.Option {
 	return func(b dialects.Builder) {
 		if f != nil {
@@ -213,3 +634,10 @@ func Extension(f dialects.Extension) dialects.Option {
 		}
 	}
 }
+func Retry(policy *dialects.RetryPolicy) dialects.Option {
+	return func(b dialects.Builder) {
+		if policy != nil {
+			b.(*Builder).Retry = policy
+		}
+	}
+}
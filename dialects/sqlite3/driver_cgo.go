@@ -0,0 +1,71 @@
+//go:build cgo && !purego
+
+package sqlite3
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"github.com/mattn/go-sqlite3"
+	"gorm.io/driver/sqlite"
+	"strconv"
+	"sync"
+)
+
+// Dialector is that of gorm.io/driver/sqlite, which uses mattn/go-sqlite3 and needs cgo.
+// Build with the purego tag or CGO_ENABLED=0 for the pure Go driver instead.
+type Dialector = sqlite.Dialector
+
+// newDriver runs Pragmas on every new connection because go-sqlite3 only takes known pragmas from the DSN.
+func newDriver(b *Builder) driver.Driver {
+	pragmas := b.customPragmas()
+	if len(pragmas) == 0 {
+		return &sqlite3.SQLiteDriver{}
+	}
+	return &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			for _, p := range pragmas {
+				if _, err := conn.Exec("PRAGMA "+p[0]+" = "+p[1], nil); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+// driverParams maps the options to the parameters of go-sqlite3, e.g. _journal_mode=WAL.
+func driverParams(b *Builder) [][2]string {
+	params := make([][2]string, 0, 6)
+	for _, p := range b.pragmas() {
+		params = append(params, [2]string{"_" + p[0], p[1]})
+	}
+	if b.TxLock != "" {
+		params = append(params, [2]string{"_txlock", b.TxLock})
+	}
+	return params
+}
+
+var drivers = struct {
+	mu    sync.Mutex
+	names map[string]string
+}{names: make(map[string]string)}
+
+// driverName registers newDriver once for each set of Pragmas, which the default driver would not run.
+// gorm opens the pool with it, so that Build does not open a pool that no one closes.
+func (b *Builder) driverName() string {
+	pragmas := b.customPragmas()
+	if len(pragmas) == 0 {
+		return ""
+	}
+	key := fmt.Sprint(pragmas)
+	drivers.mu.Lock()
+	defer drivers.mu.Unlock()
+	name, ok := drivers.names[key]
+	if !ok {
+		name = "gormsource_sqlite3_" + strconv.Itoa(len(drivers.names))
+		sql.Register(name, newDriver(b))
+		drivers.names[key] = name
+	}
+	return name
+}
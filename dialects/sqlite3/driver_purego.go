@@ -0,0 +1,41 @@
+//go:build !cgo || purego
+
+package sqlite3
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"github.com/glebarez/sqlite"
+)
+
+// Dialector is that of github.com/glebarez/sqlite, which uses the pure Go port of SQLite from modernc.org.
+// It is chosen when cgo is disabled or with the purego tag.
+type Dialector = sqlite.Dialector
+
+// newDriver returns the registered driver, which keeps the functions added with go-sqlite.RegisterScalarFunction.
+func newDriver(_ *Builder) driver.Driver {
+	db, err := sql.Open(sqlite.DriverName, "")
+	if err != nil {
+		panic(err)
+	}
+	defer db.Close()
+	return db.Driver()
+}
+
+// driverParams passes the options and Pragmas as _pragma parameters, e.g. _pragma=journal_mode(WAL).
+func driverParams(b *Builder) [][2]string {
+	pragmas := append(b.pragmas(), b.customPragmas()...)
+	params := make([][2]string, 0, len(pragmas)+1)
+	for _, p := range pragmas {
+		params = append(params, [2]string{"_pragma", p[0] + "(" + p[1] + ")"})
+	}
+	if b.TxLock != "" {
+		params = append(params, [2]string{"_txlock", b.TxLock})
+	}
+	return params
+}
+
+// driverName is empty because the Pragmas are passed in the DSN.
+func (b *Builder) driverName() string {
+	return ""
+}
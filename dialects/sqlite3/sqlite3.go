@@ -1,13 +1,28 @@
 package sqlite3
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
 	"github.com/goccha/envar"
 	"github.com/goccha/gormsource/pkg/dialects"
-	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 )
 
+func init() {
+	dialects.Register(func(params map[string]string) (dialects.Builder, error) {
+		return FromParams(params)
+	}, "sqlite3", "sqlite")
+	dialects.RegisterEnv(func(prefix string) dialects.Option {
+		return Env(EnvWithPrefix(prefix))
+	}, "sqlite3", "sqlite")
+}
+
 func New(options ...dialects.Option) *Builder {
 	b := &Builder{}
 	for _, opt := range options {
@@ -18,32 +33,352 @@ func New(options ...dialects.Option) *Builder {
 
 type Builder struct {
 	Path string
+	// Mode is ro, rw, rwc or memory.
+	Mode        string
+	SharedCache bool
+	// JournalMode is DELETE, TRUNCATE, PERSIST, MEMORY, WAL or OFF.
+	JournalMode string
+	// Synchronous is OFF, NORMAL, FULL or EXTRA.
+	Synchronous string
+	BusyTimeout time.Duration
+	ForeignKeys *bool
+	// CacheSize is a number of pages, or of KiB when negative.
+	CacheSize int
+	// TxLock is deferred, immediate or exclusive, the mode of BEGIN.
+	TxLock string
+	// Pragmas run on every new connection, e.g. {"temp_store": "memory"}.
+	Pragmas map[string]string
 }
 
 func (b *Builder) Name() string {
 	return "sqlite3"
 }
 
+func (b *Builder) Put(k string, v string) *Builder {
+	if b.Pragmas == nil {
+		b.Pragmas = make(map[string]string)
+	}
+	b.Pragmas[k] = v
+	return b
+}
+
 func (b *Builder) BuildDialector(url string) gorm.Dialector {
-	return sqlite.Open(url)
+	return &Dialector{DriverName: b.driverName(), DSN: url}
 }
 
+// BuildString returns Path as it is when no other option is set, otherwise a file: URI such as
+// file:app.db?mode=rwc&_journal_mode=WAL&_busy_timeout=5000. Parameters already in Path are kept
+// unless an option overrides them.
 func (b *Builder) BuildString(user, password, host string, port int, dbname string) string {
+	params := b.params()
+	if len(params) == 0 {
+		return b.Path
+	}
+	path, query := b.Path, ""
+	if i := strings.IndexRune(path, '?'); i >= 0 {
+		path, query = path[:i], path[i+1:]
+	}
+	if strings.HasPrefix(path, "file:") {
+		path = strings.TrimPrefix(path, "file:")
+	} else {
+		path = strings.NewReplacer("%", "%25", "?", "%3f", "#", "%23").Replace(path)
+	}
+	if path == "" {
+		path = ":memory:"
+	}
+	override := make(map[string]bool)
+	for _, p := range params {
+		if k := p[0]; k != "_pragma" {
+			override[k] = true
+		}
+	}
 	buf := &strings.Builder{}
-	buf.WriteString(b.Path)
+	buf.WriteString("file:")
+	buf.WriteString(path)
+	sep := "?"
+	for _, kv := range strings.Split(query, "&") {
+		if kv == "" || override[strings.SplitN(kv, "=", 2)[0]] {
+			continue
+		}
+		buf.WriteString(sep)
+		buf.WriteString(kv)
+		sep = "&"
+	}
+	for _, p := range params {
+		dialects.WriteString(buf, p[0], escape.Replace(p[1]), sep)
+		sep = "&"
+	}
 	return buf.String()
 }
 
+var escape = strings.NewReplacer("%", "%25", "&", "%26", "#", "%23", "+", "%2B", " ", "%20")
+
+// params returns the URI parameters of mode and cache followed by those of the driver.
+func (b *Builder) params() [][2]string {
+	params := make([][2]string, 0, 8)
+	if b.Mode != "" {
+		params = append(params, [2]string{"mode", b.Mode})
+	}
+	if b.SharedCache {
+		params = append(params, [2]string{"cache", "shared"})
+	}
+	return append(params, driverParams(b)...)
+}
+
+// pragmas returns those of the options, without Pragmas.
+func (b *Builder) pragmas() [][2]string {
+	pragmas := make([][2]string, 0, 5)
+	if b.JournalMode != "" {
+		pragmas = append(pragmas, [2]string{"journal_mode", b.JournalMode})
+	}
+	if b.Synchronous != "" {
+		pragmas = append(pragmas, [2]string{"synchronous", b.Synchronous})
+	}
+	if b.BusyTimeout > 0 {
+		pragmas = append(pragmas, [2]string{"busy_timeout", strconv.FormatInt(b.BusyTimeout.Milliseconds(), 10)})
+	}
+	if b.ForeignKeys != nil {
+		v := "0"
+		if *b.ForeignKeys {
+			v = "1"
+		}
+		pragmas = append(pragmas, [2]string{"foreign_keys", v})
+	}
+	if b.CacheSize != 0 {
+		pragmas = append(pragmas, [2]string{"cache_size", strconv.Itoa(b.CacheSize)})
+	}
+	return pragmas
+}
+
+// customPragmas returns Pragmas sorted by name.
+func (b *Builder) customPragmas() [][2]string {
+	keys := make([]string, 0, len(b.Pragmas))
+	for k := range b.Pragmas {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pragmas := make([][2]string, 0, len(keys))
+	for _, k := range keys {
+		pragmas = append(pragmas, [2]string{k, b.Pragmas[k]})
+	}
+	return pragmas
+}
+
+func (b *Builder) Redacted(user, password, host string, port int, dbname string) string {
+	return dialects.RedactDSN(b.BuildString(user, password, host, port, dbname))
+}
+
 func (b *Builder) Build(user, password, host string, port int, dbname string) gorm.Dialector {
-	return sqlite.Open(b.BuildString(user, password, host, port, dbname))
+	return &Dialector{DriverName: b.driverName(), DSN: b.BuildString(user, password, host, port, dbname)}
+}
+
+func (b *Builder) BuildE(_ context.Context, user, password, host string, port int, dbname string) (gorm.Dialector, error) {
+	return b.Build(user, password, host, port, dbname), nil
+}
+
+func (b *Builder) Driver() driver.Driver {
+	return newDriver(b)
+}
+
+func (b *Builder) BuildConn(dsn string, conn *sql.DB) gorm.Dialector {
+	return &Dialector{
+		DSN:  dsn,
+		Conn: conn,
+	}
+}
+
+// DefaultBusyTimeout is the busy timeout both drivers set on new connections: mattn/go-sqlite3 through
+// _busy_timeout and glebarez/go-sqlite by running PRAGMA busy_timeout(5000).
+const DefaultBusyTimeout = 5 * time.Second
+
+// TimeoutStatements maps lock to busy_timeout. SQLite has no statement timeout, so statement is ignored.
+func (b *Builder) TimeoutStatements(_, lock time.Duration) []string {
+	if lock > 0 {
+		return []string{fmt.Sprintf("PRAGMA busy_timeout = %d", lock.Milliseconds())}
+	}
+	return nil
+}
+
+func (b *Builder) LocalTimeoutStatements(_, lock, _, defaultLock time.Duration) (set, restore []string) {
+	if lock <= 0 {
+		return nil, nil
+	}
+	if defaultLock <= 0 {
+		defaultLock = b.BusyTimeout
+	}
+	if defaultLock <= 0 {
+		defaultLock = DefaultBusyTimeout
+	}
+	return b.TimeoutStatements(0, lock), []string{fmt.Sprintf("PRAGMA busy_timeout = %d", defaultLock.Milliseconds())}
+}
+
+// LockStatements begin an exclusive transaction because SQLite locks the whole database file. name is ignored.
+func (b *Builder) LockStatements(_ string) (lock, unlock string, transaction bool) {
+	return "BEGIN EXCLUSIVE", "COMMIT", true
+}
+
+func (b *Builder) TransactionalDDL() bool {
+	return true
+}
+
+func (b *Builder) Validate() error {
+	errs := dialects.MultiError{}
+	check := func(name, value string, valid ...string) {
+		if value == "" {
+			return
+		}
+		for _, v := range valid {
+			if strings.EqualFold(value, v) {
+				return
+			}
+		}
+		errs.Addf("unknown %s %q", name, value)
+	}
+	check("mode", b.Mode, "ro", "rw", "rwc", "memory")
+	check("journal_mode", b.JournalMode, "DELETE", "TRUNCATE", "PERSIST", "MEMORY", "WAL", "OFF")
+	check("synchronous", b.Synchronous, "OFF", "NORMAL", "FULL", "EXTRA", "0", "1", "2", "3")
+	check("txlock", b.TxLock, "deferred", "immediate", "exclusive")
+	if b.BusyTimeout < 0 {
+		errs.Addf("busy_timeout must not be negative")
+	}
+	return errs.Err()
+}
+
+// FromParams creates a Builder from path and the names of the options such as journal_mode or busy_timeout,
+// which is in milliseconds. Unknown names are treated as pragmas.
+func FromParams(params map[string]string) (*Builder, error) {
+	b := New()
+	for k, v := range params {
+		var err error
+		switch k {
+		case "path":
+			Path(v)(b)
+		case "mode":
+			Mode(v)(b)
+		case "cache":
+			SharedCache(v == "shared")(b)
+		case "journal_mode":
+			JournalMode(v)(b)
+		case "synchronous":
+			Synchronous(v)(b)
+		case "busy_timeout":
+			var ms int
+			if ms, err = strconv.Atoi(v); err == nil {
+				BusyTimeout(time.Duration(ms) * time.Millisecond)(b)
+			}
+		case "foreign_keys":
+			var fk bool
+			if fk, err = strconv.ParseBool(v); err == nil {
+				ForeignKeys(&fk)(b)
+			}
+		case "cache_size":
+			var n int
+			if n, err = strconv.Atoi(v); err == nil {
+				CacheSize(n)(b)
+			}
+		case "txlock":
+			TxLock(v)(b)
+		default:
+			b.Put(k, v)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+	}
+	return b, nil
 }
 
 type Environment struct {
-	Path string
+	Path        string
+	Mode        string
+	SharedCache string
+	JournalMode string
+	Synchronous string
+	BusyTimeout string
+	ForeignKeys string
+	CacheSize   string
+	TxLock      string
+	// Pragmas holds comma separated name=value pairs, e.g. temp_store=memory,mmap_size=268435456.
+	Pragmas string
+}
+
+// EnvWithPrefix derives every key from prefix, e.g. BILLING_SQLITE_PATH for "BILLING" and SQLITE_PATH for an empty prefix.
+func EnvWithPrefix(prefix string) Environment {
+	key := func(name string) string {
+		return dialects.EnvKey(prefix, "SQLITE_"+name)
+	}
+	return Environment{
+		Path:        key("PATH"),
+		Mode:        key("MODE"),
+		SharedCache: key("SHARED_CACHE"),
+		JournalMode: key("JOURNAL_MODE"),
+		Synchronous: key("SYNCHRONOUS"),
+		BusyTimeout: key("BUSY_TIMEOUT"),
+		ForeignKeys: key("FOREIGN_KEYS"),
+		CacheSize:   key("CACHE_SIZE"),
+		TxLock:      key("TXLOCK"),
+		Pragmas:     key("PRAGMAS"),
+	}
+}
+
+// BuildStrict is Build that fails when a variable that is set cannot be parsed.
+func (env Environment) BuildStrict(b *Builder) error {
+	errs := dialects.MultiError{}
+	errs.Add(env.Validate())
+	env.Build(b)
+	errs.Add(b.Validate())
+	return errs.Err()
+}
+
+func (env Environment) Validate() error {
+	errs := dialects.MultiError{}
+	errs.CheckBool(env.SharedCache)
+	errs.CheckDuration(env.BusyTimeout)
+	errs.CheckBool(env.ForeignKeys)
+	errs.CheckInt(env.CacheSize)
+	if v := envar.String(env.Pragmas); v != "" {
+		if _, err := parsePragmas(v); err != nil {
+			errs.Addf("%s: %v", env.Pragmas, err)
+		}
+	}
+	return errs.Err()
 }
 
 func (env Environment) Build(b *Builder) {
-	Path(envar.String(env.Path, "SQLITE_PATH"))(b)
+	Path(envar.String(env.Path))(b)
+	Mode(envar.String(env.Mode))(b)
+	if ev := envar.Get(env.SharedCache); ev.Has() {
+		SharedCache(ev.Bool(false))(b)
+	}
+	JournalMode(envar.String(env.JournalMode))(b)
+	Synchronous(envar.String(env.Synchronous))(b)
+	BusyTimeout(envar.Duration(env.BusyTimeout))(b)
+	if envar.Has(env.ForeignKeys) {
+		v := envar.Bool(env.ForeignKeys)
+		ForeignKeys(&v)(b)
+	}
+	CacheSize(envar.Int(env.CacheSize))(b)
+	TxLock(envar.String(env.TxLock))(b)
+	if pragmas, err := parsePragmas(envar.String(env.Pragmas)); err == nil {
+		for k, v := range pragmas {
+			b.Put(k, v)
+		}
+	}
+}
+
+func parsePragmas(s string) (map[string]string, error) {
+	pragmas := make(map[string]string)
+	for _, kv := range strings.Split(s, ",") {
+		if kv = strings.TrimSpace(kv); kv == "" {
+			continue
+		}
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("invalid pragma %q", kv)
+		}
+		pragmas[strings.TrimSpace(k)] = strings.TrimSpace(v)
+	}
+	return pragmas, nil
 }
 
 func Env(env Environment) dialects.Option {
@@ -58,3 +393,64 @@ func Path(value string) dialects.Option {
 		}
 	}
 }
+func Mode(value string) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).Mode = value
+		}
+	}
+}
+func SharedCache(value bool) dialects.Option {
+	return func(b dialects.Builder) {
+		b.(*Builder).SharedCache = value
+	}
+}
+func JournalMode(value string) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).JournalMode = value
+		}
+	}
+}
+func Synchronous(value string) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).Synchronous = value
+		}
+	}
+}
+func BusyTimeout(value time.Duration) dialects.Option {
+	return func(b dialects.Builder) {
+		if value > 0 {
+			b.(*Builder).BusyTimeout = value
+		}
+	}
+}
+func ForeignKeys(value *bool) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != nil {
+			b.(*Builder).ForeignKeys = value
+		}
+	}
+}
+func CacheSize(value int) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != 0 {
+			b.(*Builder).CacheSize = value
+		}
+	}
+}
+func TxLock(value string) dialects.Option {
+	return func(b dialects.Builder) {
+		if value != "" {
+			b.(*Builder).TxLock = value
+		}
+	}
+}
+
+// Pragma adds a pragma that runs on every new connection, e.g. Pragma("temp_store", "memory").
+func Pragma(name, value string) dialects.Option {
+	return func(b dialects.Builder) {
+		b.(*Builder).Put(name, value)
+	}
+}
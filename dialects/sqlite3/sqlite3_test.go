@@ -1,17 +1,42 @@
 package sqlite3
 
 import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"gorm.io/driver/sqlite"
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/dbtest"
+	"github.com/goccha/gormsource/pkg/health"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/migrations"
+	"github.com/goccha/gormsource/pkg/reload"
+	"github.com/goccha/gormsource/pkg/replicas"
+	"github.com/goccha/gormsource/pkg/sqlcomment"
+	"github.com/goccha/gormsource/pkg/tracing"
+	"github.com/goccha/gormsource/pkg/transactions"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"gorm.io/gorm"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
+	"time"
 )
 
 func TestNew(t *testing.T) {
 	b := New(Path("./test.db"))
 	actual := b.Build("", "", "", 0, "")
 	expected := "./test.db"
-	dialector := actual.(*sqlite.Dialector)
+	dialector := actual.(*Dialector)
 	if expected != dialector.DSN {
 		t.Errorf("expected=%s, actual=%s", expected, actual)
 	} else {
@@ -24,10 +49,746 @@ func TestEnv(t *testing.T) {
 	b := New(Env(Environment{Path: "SQLITE3_PATH"}))
 	actual := b.Build("", "", "", 0, "")
 	expected := "./testenv.db"
-	dialector := actual.(*sqlite.Dialector)
+	dialector := actual.(*Dialector)
 	if expected != dialector.DSN {
 		t.Errorf("expected=%s, actual=%s", expected, actual)
 	} else {
 		fmt.Printf("%s\n", actual)
 	}
 }
+
+func TestRedacted(t *testing.T) {
+	b := New(Path("file:test.db?_auth&_auth_user=admin&_auth_pass=secret"))
+	actual := b.Redacted("", "", "", 0, "")
+	expected := "file:test.db?_auth&_auth_user=admin&_auth_pass=xxxxx"
+	if expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"sqlite:///var/lib/app.db":  "/var/lib/app.db",
+		"file:test.db?cache=shared": "file:test.db?cache=shared",
+	} {
+		c, err := datasources.ParseURL(raw)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := c.DSN(); expected != actual {
+			t.Errorf("expected=%s, actual=%s", expected, actual)
+		}
+	}
+	c, err := datasources.ParseURL("sqlite:///var/lib/app.db?journal_mode=WAL&busy_timeout=5000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := c.Builder().(*Builder)
+	if b.Path != "/var/lib/app.db" || b.JournalMode != "WAL" || b.BusyTimeout != 5*time.Second {
+		t.Errorf("expected=/var/lib/app.db WAL 5s, actual=%s %s %v", b.Path, b.JournalMode, b.BusyTimeout)
+	}
+}
+
+func TestEnvWithPrefix(t *testing.T) {
+	t.Setenv("SQLITE_PATH", "./default.db")
+	if expected, actual := "./default.db", New(Env(EnvWithPrefix(""))).Path; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	// SQLITE_PATH belongs to the default environment and must not leak into a prefixed one.
+	if actual := New(Env(EnvWithPrefix("BILLING"))).Path; actual != "" {
+		t.Errorf("expected=, actual=%s", actual)
+	}
+	t.Setenv("BILLING_SQLITE_PATH", "./billing.db")
+	b := New(Env(EnvWithPrefix("BILLING")))
+	actual := b.Build("", "", "", 0, "")
+	expected := "./billing.db"
+	dialector := actual.(*Dialector)
+	if expected != dialector.DSN {
+		t.Errorf("expected=%s, actual=%s", expected, dialector.DSN)
+	}
+}
+
+func TestOptions(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "options.db")
+	fk := true
+	b := New(Path(path), Mode("rwc"), SharedCache(true), JournalMode("WAL"), Synchronous("FULL"),
+		BusyTimeout(2*time.Second), ForeignKeys(&fk), CacheSize(-4000), Pragma("temp_store", "2"))
+	expected := "file:" + path + "?mode=rwc&cache=shared&"
+	if actual := b.BuildString("", "", "", 0, ""); !strings.HasPrefix(actual, expected) {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if err := b.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	first, second := b.Build("", "", "", 0, "").(*Dialector), b.Build("", "", "", 0, "").(*Dialector)
+	if first.Conn != nil || first.DriverName != second.DriverName {
+		t.Errorf("expected Build not to open a pool, actual=%v, %s, %s", first.Conn, first.DriverName, second.DriverName)
+	}
+	db, err := gorm.Open(b.Build("", "", "", 0, ""), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sqlDb, _ := db.DB()
+	defer sqlDb.Close()
+	for pragma, expected := range map[string]string{
+		"journal_mode": "wal",
+		"synchronous":  "2",
+		"busy_timeout": "2000",
+		"foreign_keys": "1",
+		"cache_size":   "-4000",
+		"temp_store":   "2",
+	} {
+		var actual string
+		if err = db.Raw("PRAGMA " + pragma).Scan(&actual).Error; err != nil {
+			t.Fatal(err)
+		}
+		if expected != strings.ToLower(actual) {
+			t.Errorf("%s: expected=%s, actual=%s", pragma, expected, actual)
+		}
+	}
+	if err = New(Mode("rwx"), JournalMode("WALL")).Validate(); err == nil {
+		t.Errorf("expected errors for mode and journal_mode")
+	}
+}
+
+func TestEnvOptions(t *testing.T) {
+	_ = os.Setenv("ORDERS_SQLITE_PATH", "file:orders.db?_auth")
+	_ = os.Setenv("ORDERS_SQLITE_MODE", "ro")
+	_ = os.Setenv("ORDERS_SQLITE_BUSY_TIMEOUT", "10s")
+	_ = os.Setenv("ORDERS_SQLITE_PRAGMAS", "temp_store=memory, mmap_size=0")
+	b := New()
+	if err := EnvWithPrefix("ORDERS").BuildStrict(b); err != nil {
+		t.Fatal(err)
+	}
+	expected := "file:orders.db?_auth&mode=ro&"
+	if actual := b.BuildString("", "", "", 0, ""); !strings.HasPrefix(actual, expected) {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if b.BusyTimeout != 10*time.Second || b.Pragmas["temp_store"] != "memory" || b.Pragmas["mmap_size"] != "0" {
+		t.Errorf("expected busy timeout and pragmas, actual=%#v", b)
+	}
+	_ = os.Setenv("ORDERS_SQLITE_PRAGMAS", "temp_store")
+	if err := EnvWithPrefix("ORDERS").BuildStrict(New()); err == nil {
+		t.Errorf("expected an error for ORDERS_SQLITE_PRAGMAS")
+	}
+}
+
+func TestInitStatements(t *testing.T) {
+	ctx := context.Background()
+	config := (&datasources.Env{}).Build(New(Path("file::memory:")))
+	config.InitStatements = []string{"PRAGMA foreign_keys = ON"}
+	config.MaxIdleConns, config.MinIdleConns = 2, 2
+	ds, err := datasources.NewDataSourceE(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer datasources.Close(ds.GetConnection())
+	db, _ := ds.GetConnection().DB()
+	for i := 0; i < 2; i++ {
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer func() {
+			_ = conn.Close()
+		}()
+		var actual int
+		if err = conn.QueryRowContext(ctx, "PRAGMA foreign_keys").Scan(&actual); err != nil {
+			t.Fatal(err)
+		}
+		if expected := 1; expected != actual {
+			t.Errorf("expected=%d, actual=%d", expected, actual)
+		}
+	}
+}
+
+func TestTimeoutStatements(t *testing.T) {
+	set, restore := New().LocalTimeoutStatements(time.Minute, 700*time.Millisecond, 0, 0)
+	actual := strings.Join(append(set, restore...), "; ")
+	expected := "PRAGMA busy_timeout = 700; PRAGMA busy_timeout = 5000"
+	if expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
+
+func TestDefaultBusyTimeout(t *testing.T) {
+	config := (&datasources.Env{}).Build(New(Path(filepath.Join(t.TempDir(), "busy.db"))))
+	ds, err := datasources.NewDataSourceE(context.Background(), config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer datasources.Close(ds.GetConnection())
+	var ms int64
+	if err = ds.GetConnection().Raw("PRAGMA busy_timeout").Scan(&ms).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expected := DefaultBusyTimeout.Milliseconds(); expected != ms {
+		t.Errorf("expected=%d, actual=%d", expected, ms)
+	}
+}
+
+func TestTracing(t *testing.T) {
+	ctx := context.Background()
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	t.Cleanup(tracing.TraceTransactions(tp))
+	config := (&datasources.Env{}).Build(New(Path("file::memory:")))
+	config.Tracing = &tracing.Plugin{TracerProvider: tp}
+	ds, err := datasources.NewDataSourceE(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer datasources.Close(ds.GetConnection())
+	if _, err = transactions.Setup(func() (*gorm.DB, error) { return ds.GetConnection(), nil }); err != nil {
+		t.Fatal(err)
+	}
+	_, err = transactions.Run(ctx, func(ctx context.Context, db *gorm.DB) (int, error) {
+		transactions.HandleCommit(ctx, func(ctx context.Context) {})
+		return 0, db.Exec("CREATE TABLE items (id integer)").Error
+	}, &sql.TxOptions{Isolation: sql.LevelSerializable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if expected, actual := 2, len(spans); expected != actual {
+		t.Fatalf("expected=%d, actual=%d", expected, actual)
+	}
+	statement, transaction := spans[0], spans[1]
+	if expected, actual := transaction.SpanContext.SpanID(), statement.Parent.SpanID(); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if expected, actual := "CREATE", statement.Name; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	actual := fmt.Sprint(attributes(statement.Attributes, "db.system", "db.operation.name", "db.query.text"))
+	if expected := "[sqlite CREATE CREATE TABLE items (id integer)]"; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	actual = fmt.Sprint(attributes(transaction.Attributes, "gormsource.transaction.type",
+		"gormsource.transaction.isolation", "gormsource.transaction.outcome", "gormsource.transaction.hooks"))
+	if expected := "[transaction Serializable commit 1]"; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
+
+func attributes(attrs []attribute.KeyValue, keys ...string) []string {
+	values := make([]string, 0, len(keys))
+	for _, k := range keys {
+		for _, a := range attrs {
+			if string(a.Key) == k {
+				values = append(values, a.Value.Emit())
+			}
+		}
+	}
+	return values
+}
+
+// recordingDriver records the statements that reach the driver and the ends of the transactions.
+// Its connections only implement driver.Conn, so that every statement is prepared.
+type recordingDriver struct {
+	driver.Driver
+	mu         sync.Mutex
+	statements []string
+}
+
+func (d *recordingDriver) add(s string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.statements = append(d.statements, s)
+}
+
+// take returns the statements recorded since the last call.
+func (d *recordingDriver) take() []string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	v := d.statements
+	d.statements = nil
+	return v
+}
+
+func (d *recordingDriver) Open(name string) (driver.Conn, error) {
+	c, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &recordingConn{Conn: c, d: d}, nil
+}
+
+type recordingConn struct {
+	driver.Conn
+	d *recordingDriver
+}
+
+func (c *recordingConn) Prepare(query string) (driver.Stmt, error) {
+	c.d.add(query)
+	return c.Conn.Prepare(query)
+}
+
+func (c *recordingConn) Begin() (driver.Tx, error) { //nolint:staticcheck
+	tx, err := c.Conn.Begin() //nolint:staticcheck
+	if err != nil {
+		return nil, err
+	}
+	c.d.add("BEGIN")
+	return &recordingTx{Tx: tx, d: c.d}, nil
+}
+
+type recordingTx struct {
+	driver.Tx
+	d *recordingDriver
+}
+
+func (tx *recordingTx) Commit() error {
+	tx.d.add("COMMIT")
+	return tx.Tx.Commit()
+}
+
+func (tx *recordingTx) Rollback() error {
+	tx.d.add("ROLLBACK")
+	return tx.Tx.Rollback()
+}
+
+type recordingConnector struct {
+	d   *recordingDriver
+	dsn string
+}
+
+func (c recordingConnector) Connect(context.Context) (driver.Conn, error) {
+	return c.d.Open(c.dsn)
+}
+
+func (c recordingConnector) Driver() driver.Driver {
+	return c.d
+}
+
+func TestSQLComment(t *testing.T) {
+	b := New(Path(filepath.Join(t.TempDir(), "comment.db")))
+	d := &recordingDriver{Driver: b.Driver()}
+	dsn := b.BuildString("", "", "", 0, "")
+	conn := sql.OpenDB(recordingConnector{d: d, dsn: dsn})
+	defer conn.Close()
+	db, err := gorm.Open(b.BuildConn(dsn, conn), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Use(sqlcomment.New()); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Exec("CREATE TABLE items (id integer primary key, name text)").Error; err != nil {
+		t.Fatal(err)
+	}
+	d.take()
+	type item struct {
+		ID   int
+		Name string
+	}
+	ctx := logging.WithRequestID(sqlcomment.WithRoute(context.Background(), "GET /items"), "req-1")
+	db = db.WithContext(ctx)
+	comment := " /*request_id='req-1',route='GET%20%2Fitems'*/"
+
+	if err = db.Create(&item{Name: "a"}).Error; err != nil {
+		t.Fatal(err)
+	}
+	// after must restore the *sql.Tx before gorm:commit_or_rollback_transaction, or Create never commits.
+	statements := d.take()
+	if len(statements) != 3 || statements[0] != "BEGIN" || !strings.HasSuffix(statements[1], comment) || statements[2] != "COMMIT" {
+		t.Errorf("expected=BEGIN INSERT ...%s COMMIT, actual=%q", comment, statements)
+	}
+	if expected, actual := 0, conn.Stats().InUse; expected != actual {
+		t.Errorf("expected=%d, actual=%d", expected, actual)
+	}
+
+	var items []item
+	if err = db.Find(&items).Error; err != nil {
+		t.Fatal(err)
+	}
+	if statements = d.take(); len(statements) != 1 || !strings.HasSuffix(statements[0], comment) {
+		t.Errorf("expected=SELECT ...%s, actual=%q", comment, statements)
+	}
+	if len(items) != 1 || items[0].Name != "a" {
+		t.Errorf("expected=[a], actual=%v", items)
+	}
+
+	var n int
+	if err = db.Raw("SELECT count(*) FROM items").Scan(&n).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := []string{"SELECT count(*) FROM items" + comment}, d.take(); fmt.Sprint(expected) != fmt.Sprint(actual) {
+		t.Errorf("expected=%q, actual=%q", expected, actual)
+	}
+	if n != 1 {
+		t.Errorf("expected=1, actual=%d", n)
+	}
+
+	// Update and Delete run in a transaction of their own as well.
+	for _, f := range []func() error{
+		func() error { return db.Model(&item{ID: 1}).Update("name", "b").Error },
+		func() error { return db.Delete(&item{ID: 1}).Error },
+	} {
+		if err = f(); err != nil {
+			t.Fatal(err)
+		}
+		if statements = d.take(); len(statements) != 3 || statements[0] != "BEGIN" || !strings.HasSuffix(statements[1], comment) || statements[2] != "COMMIT" {
+			t.Errorf("expected=BEGIN ...%s COMMIT, actual=%q", comment, statements)
+		}
+	}
+}
+
+func TestDBTest(t *testing.T) {
+	ctx := context.Background()
+	t.Run("rollback", func(t *testing.T) {
+		db := dbtest.Open(t, dbtest.WithRollback())
+		if err := db.Exec("CREATE TABLE items (id integer)").Error; err != nil {
+			t.Fatal(err)
+		}
+		_, err := transactions.Run(ctx, func(ctx context.Context, db *gorm.DB) (int, error) {
+			return 0, db.Exec("INSERT INTO items VALUES (1)").Error
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = transactions.Run(ctx, func(ctx context.Context, db *gorm.DB) (int, error) {
+			if err := db.Exec("INSERT INTO items VALUES (2)").Error; err != nil {
+				return 0, err
+			}
+			return 0, fmt.Errorf("rollback")
+		})
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		actual, err := replicas.Run(ctx, func(ctx context.Context, db *gorm.DB) (count int64, err error) {
+			err = db.Table("items").Count(&count).Error
+			return
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := int64(1); expected != actual {
+			t.Errorf("expected=%d, actual=%d", expected, actual)
+		}
+	})
+	t.Run("isolated", func(t *testing.T) {
+		db := dbtest.Open(t)
+		if db.Migrator().HasTable("items") {
+			t.Error("expected a new database")
+		}
+	})
+}
+
+func TestMigrations(t *testing.T) {
+	ctx := context.Background()
+	db := dbtest.Open(t)
+	fsys := fstest.MapFS{
+		"0001_create_users.up.sql":   {Data: []byte("CREATE TABLE users (id integer primary key, name text);\nCREATE TABLE user_counts (n integer);\nINSERT INTO user_counts VALUES (0);\nCREATE TRIGGER count_users AFTER INSERT ON users BEGIN\n  UPDATE user_counts SET n = n + 1;\nEND;\n-- seed; comment\nINSERT INTO users VALUES (1, 'a;b');")},
+		"0001_create_users.down.sql": {Data: []byte("DROP TABLE users;\nDROP TABLE user_counts;")},
+		"0002_add_email.up.sql":      {Data: []byte("ALTER TABLE users ADD COLUMN email text;")},
+		"0002_add_email.down.sql":    {Data: []byte("ALTER TABLE users DROP COLUMN email;")},
+		"0003_broken.up.sql":         {Data: []byte("CREATE TABLE broken (id integer); INSERT INTO missing VALUES (1);")},
+		"README.md":                  {Data: []byte("ignored")},
+	}
+	m, err := migrations.New(db, New(), fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	applied, err := m.Up(ctx)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if expected, actual := "[1_create_users 2_add_email]", fmt.Sprint(applied); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if db.Migrator().HasTable("broken") {
+		t.Error("expected 3_broken to be rolled back")
+	}
+	var name string
+	if err = db.Raw("SELECT name FROM users WHERE id = 1").Scan(&name).Error; err != nil {
+		t.Fatal(err)
+	}
+	if expected := "a;b"; expected != name {
+		t.Errorf("expected=%s, actual=%s", expected, name)
+	}
+	var count int
+	if err = db.Raw("SELECT n FROM user_counts").Scan(&count).Error; err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected=1, actual=%d", count)
+	}
+	delete(fsys, "0003_broken.up.sql")
+	if m, err = migrations.New(db, New(), fsys); err != nil {
+		t.Fatal(err)
+	}
+	reverted, err := m.Down(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "[2_add_email]", fmt.Sprint(reverted); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	statuses, err := m.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual := make([]string, 0, len(statuses))
+	for _, s := range statuses {
+		actual = append(actual, fmt.Sprintf("%s:%t", s.Migration, s.Applied))
+	}
+	if expected := "1_create_users:true 2_add_email:false"; expected != strings.Join(actual, " ") {
+		t.Errorf("expected=%s, actual=%s", expected, strings.Join(actual, " "))
+	}
+	fsys["0001_create_users.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE users (id integer);")}
+	if m, err = migrations.New(db, New(), fsys); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = m.Up(ctx); !errors.Is(err, migrations.ErrChecksum) {
+		t.Errorf("expected=%v, actual=%v", migrations.ErrChecksum, err)
+	}
+}
+
+func TestReplicaPool(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	primary, err := datasources.ParseURL("sqlite://" + filepath.Join(dir, "primary.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	primary.PoolConfig = datasources.PoolConfig{MaxIdleConns: 3, MaxOpenConns: 5, MinIdleConns: 2}
+	_ = os.Setenv("POOLTEST_REPLICA_0_CONNECT_URL", filepath.Join(dir, "replica0.db"))
+	_ = os.Setenv("POOLTEST_REPLICA_1_CONNECT_URL", filepath.Join(dir, "replica1.db"))
+	connectors := make([]replicas.Connector, 0)
+	for _, c := range datasources.EnvWithPrefix("POOLTEST").BuildReplicas(primary) {
+		connectors = append(connectors, c.Connector(ctx))
+	}
+	db, err := replicas.New(connectors...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	stats := db.Stats()
+	if len(stats) != 2 {
+		t.Fatalf("expected=2, actual=%d", len(stats))
+	}
+	for _, s := range stats {
+		if s.MaxOpenConnections != 5 || s.Idle != 2 {
+			t.Errorf("expected=5/2, actual=%d/%d", s.MaxOpenConnections, s.Idle)
+		}
+	}
+}
+
+func TestReplicaSwap(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	connector := func(name string) replicas.Connector {
+		config, err := datasources.ParseURL("sqlite://" + filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return config.Connector(ctx)
+	}
+	db, err := replicas.New(connector("old.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	started, finish := make(chan struct{}), make(chan struct{})
+	result := make(chan error, 1)
+	go func() {
+		_, err := replicas.Run(replicas.Begin(ctx, db), func(ctx context.Context, tx *gorm.DB) (n int, err error) {
+			close(started)
+			<-finish
+			err = tx.Raw("SELECT 1").Scan(&n).Error
+			return
+		})
+		result <- err
+	}()
+	<-started
+	swapped := make(chan error, 1)
+	go func() {
+		swapped <- db.Swap(ctx, connector("new.db"))
+	}()
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case err = <-swapped:
+		t.Fatalf("expected Swap to wait for the transaction: %v", err)
+	default:
+	}
+	if err = db.Use(&noopPlugin{}); err != nil {
+		t.Fatal(err)
+	}
+	close(finish)
+	if err = <-result; err != nil {
+		t.Errorf("expected=nil, actual=%v", err)
+	}
+	if err = <-swapped; err != nil {
+		t.Fatal(err)
+	}
+	if _, err = replicas.Run(replicas.Begin(ctx, db), func(ctx context.Context, tx *gorm.DB) (n int, err error) {
+		err = tx.Raw("SELECT 1").Scan(&n).Error
+		return
+	}); err != nil {
+		t.Errorf("expected=nil, actual=%v", err)
+	}
+}
+
+func TestReloader(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config := func(name string, maxOpen int) *datasources.Config {
+		c, err := datasources.ParseURL("sqlite://" + filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		c.PoolConfig = datasources.PoolConfig{MaxIdleConns: 1, MaxOpenConns: maxOpen}
+		return c
+	}
+	maxOpen := func(db *replicas.DB) []int {
+		v := make([]int, 0)
+		for _, s := range db.Stats() {
+			v = append(v, s.MaxOpenConnections)
+		}
+		return v
+	}
+	current := []*datasources.Config{config("replica0.db", 2), config("replica1.db", 2)}
+	connectors := make([]replicas.Connector, 0, len(current))
+	for _, c := range current {
+		connectors = append(connectors, c.Connector(ctx))
+	}
+	db, err := replicas.New(connectors...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	members := db.Members()
+
+	r := reload.New(nil, db, current...)
+	if err = r.Apply(ctx, nil, []*datasources.Config{config("replica0.db", 7), config("replica1.db", 7)}); err != nil {
+		t.Fatal(err)
+	}
+	if actual := db.Members(); len(actual) != 2 || actual[0] != members[0] || actual[1] != members[1] {
+		t.Errorf("expected the pool to change in place, actual=%v", actual)
+	}
+	if expected, actual := "[7 7]", fmt.Sprint(maxOpen(db)); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+
+	// without the current configs an empty list must neither panic nor drop the replicas.
+	if err = reload.New(nil, db).Apply(ctx, nil, []*datasources.Config{}); err == nil {
+		t.Errorf("expected an error for an empty replica list")
+	}
+	if expected, actual := 2, len(db.Members()); expected != actual {
+		t.Errorf("expected=%d, actual=%d", expected, actual)
+	}
+
+	// the configs match the ones the reloader knows, but not the running replicas.
+	if err = reload.New(nil, db, current[0]).Apply(ctx, nil, []*datasources.Config{config("replica0.db", 3)}); err != nil {
+		t.Fatal(err)
+	}
+	if actual := db.Members(); len(actual) != 1 || actual[0] == members[0] {
+		t.Errorf("expected the replicas to be swapped, actual=%v", actual)
+	}
+	if expected, actual := "[3]", fmt.Sprint(maxOpen(db)); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
+
+func TestHealthCheck(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	config, err := datasources.ParseURL("sqlite://" + filepath.Join(dir, "primary.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ds, err := datasources.NewDataSourceE(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connectors := make([]replicas.Connector, 0)
+	for _, name := range []string{"replica0.db", "replica1.db"} {
+		c, err := datasources.ParseURL("sqlite://" + filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		connectors = append(connectors, c.Connector(ctx))
+	}
+	db, err := replicas.New(connectors...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	datasources.Close(db.Members()[1])
+	h := health.NewHandler().Add("primary", ds).Add("replicas", db)
+	ready := func() (int, *health.Response) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/ready", nil))
+		res := &health.Response{}
+		if err := json.Unmarshal(rec.Body.Bytes(), res); err != nil {
+			t.Fatal(err)
+		}
+		return rec.Code, res
+	}
+	code, res := ready()
+	if code != http.StatusOK || res.Status != datasources.StatusDegraded {
+		t.Errorf("expected=200/degraded, actual=%d/%s", code, res.Status)
+	}
+	members := make([]string, 0)
+	for _, c := range res.Checks["replicas"].Connections {
+		members = append(members, c.Name+":"+string(c.Status))
+	}
+	if expected, actual := "replica-0:up replica-1:down", strings.Join(members, " "); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	datasources.Close(ds.GetConnection())
+	if code, res = ready(); code != http.StatusServiceUnavailable || res.Checks["primary"].Status != datasources.StatusDown {
+		t.Errorf("expected=503/down, actual=%d/%s", code, res.Checks["primary"].Status)
+	}
+}
+
+type noopPlugin struct{}
+
+func (p *noopPlugin) Name() string {
+	return "noop"
+}
+
+func (p *noopPlugin) Initialize(*gorm.DB) error {
+	return nil
+}
+
+type lockQueryBuilder struct {
+	*Builder
+	lock string
+}
+
+func (b lockQueryBuilder) LockStatements(_ string) (lock, unlock string, transaction bool) {
+	return b.lock, "SELECT 1", false
+}
+
+func (b lockQueryBuilder) LockQuery() bool {
+	return true
+}
+
+func TestMigrationLockQuery(t *testing.T) {
+	ctx := context.Background()
+	fsys := fstest.MapFS{
+		"0001_create_items.up.sql":   {Data: []byte("CREATE TABLE items (id integer);")},
+		"0001_create_items.down.sql": {Data: []byte("DROP TABLE items;")},
+	}
+	tests := []struct {
+		lock string
+		err  error
+	}{
+		{lock: "SELECT 0", err: migrations.ErrNotLocked},
+		{lock: "SELECT NULL", err: migrations.ErrNotLocked},
+		{lock: "SELECT 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.lock, func(t *testing.T) {
+			m, err := migrations.New(dbtest.Open(t), lockQueryBuilder{Builder: New(), lock: tt.lock}, fsys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = m.Up(ctx); !errors.Is(err, tt.err) {
+				t.Errorf("expected=%v, actual=%v", tt.err, err)
+			}
+		})
+	}
+}
@@ -1,11 +1,25 @@
 package datasources
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/sqlcomment"
+	"github.com/goccha/gormsource/pkg/tracing"
+	"github.com/pkg/errors"
 	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	stdlog "log"
+	"log/slog"
+	"os"
 	"time"
 )
 
+var ErrNoDialect = errors.New("dialect is not configured")
+
 type Config struct {
 	User             string
 	Pass             string
@@ -15,31 +29,263 @@ type Config struct {
 	dialect          dialects.Builder
 	ConnectionString string
 	PoolConfig
-	Debug bool
+	Retry       *dialects.RetryPolicy
+	Credentials CredentialProvider
+	Debug       bool
+	Log         LogConfig
+	// SQLComment annotates every statement with the route, request ID and other values of its context.
+	SQLComment *sqlcomment.Plugin
+	// Tracing starts a span for every statement.
+	Tracing *tracing.Plugin
+	// StatementTimeout and LockTimeout are set on every new physical connection in the way of the dialect.
+	StatementTimeout time.Duration
+	LockTimeout      time.Duration
+	// InitStatements run on every new physical connection, e.g. SET time_zone = '+00:00'.
+	InitStatements []string
+	// OnConnect is called on every new physical connection after InitStatements.
+	OnConnect func(ctx context.Context, conn *Conn) error
 	gorm.Config
 }
 
+// LogConfig configures the GORM logger when gorm.Config.Logger is not set.
+type LogConfig struct {
+	// Slog writes GORM logs to slog instead of the GORM default logger.
+	Slog                      *slog.Logger
+	SlowThreshold             time.Duration
+	IgnoreRecordNotFoundError bool
+	// ParameterizedQueries logs SQL without its parameters.
+	ParameterizedQueries bool
+}
+
+func (l LogConfig) logger() logger.Interface {
+	config := logger.Config{
+		SlowThreshold:             l.SlowThreshold,
+		IgnoreRecordNotFoundError: l.IgnoreRecordNotFoundError,
+		ParameterizedQueries:      l.ParameterizedQueries,
+		LogLevel:                  logger.Warn,
+	}
+	if config.SlowThreshold == 0 {
+		config.SlowThreshold = 200 * time.Millisecond
+	}
+	if l.Slog != nil {
+		return logging.NewGormLogger(l.Slog, config)
+	}
+	config.Colorful = true
+	return logger.New(stdlog.New(os.Stdout, "\r\n", stdlog.LstdFlags), config)
+}
+
 func (c *Config) Dialect(builder dialects.Builder) *Config {
 	c.dialect = builder
 	return c
 }
 
+func (c *Config) Builder() dialects.Builder {
+	return c.dialect
+}
+
+func (c *Config) retryPolicy() *dialects.RetryPolicy {
+	policy := c.Retry
+	if policy == nil {
+		policy = dialects.DefaultRetryPolicy()
+	}
+	if v, ok := c.dialect.(dialects.PermanentErrors); ok {
+		return policy.WithPermanent(v.IsPermanent)
+	}
+	return policy
+}
+
 type PoolConfig struct {
 	MaxIdleConns    int
 	MaxOpenConns    int
 	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	MinIdleConns    int
 }
 
-func (c *Config) String() string {
-	if len(c.ConnectionString) > 0 {
+func (p PoolConfig) Validate() error {
+	errs := dialects.MultiError{}
+	if p.MaxIdleConns < 0 || p.MaxOpenConns < 0 || p.MinIdleConns < 0 {
+		errs.Addf("connection limits must not be negative")
+	}
+	if p.MaxOpenConns > 0 && p.MaxIdleConns > p.MaxOpenConns {
+		errs.Addf("max idle connections %d exceed max open connections %d", p.MaxIdleConns, p.MaxOpenConns)
+	}
+	if p.MinIdleConns > p.MaxIdleConns {
+		errs.Addf("min idle connections %d exceed max idle connections %d", p.MinIdleConns, p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime < 0 || p.ConnMaxIdleTime < 0 {
+		errs.Addf("connection lifetimes must not be negative")
+	}
+	return errs.Err()
+}
+
+// Apply sets the pool limits on db and, when MinIdleConns is set, opens and pings that many connections.
+func (p PoolConfig) Apply(ctx context.Context, db *gorm.DB) error {
+	sqlDb, err := db.DB()
+	if err != nil {
+		return err
+	}
+	sqlDb.SetMaxIdleConns(p.MaxIdleConns)
+	sqlDb.SetMaxOpenConns(p.MaxOpenConns)
+	sqlDb.SetConnMaxLifetime(p.ConnMaxLifetime)
+	sqlDb.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+	return p.warmup(ctx, sqlDb)
+}
+
+func (p PoolConfig) warmup(ctx context.Context, db *sql.DB) error {
+	n := p.MinIdleConns
+	if n > p.MaxIdleConns {
+		n = p.MaxIdleConns
+	}
+	if p.MaxOpenConns > 0 && n > p.MaxOpenConns {
+		n = p.MaxOpenConns
+	}
+	if n <= 0 {
+		return nil
+	}
+	conns := make([]*sql.Conn, 0, n)
+	defer func() {
+		for _, c := range conns {
+			_ = c.Close()
+		}
+	}()
+	for i := 0; i < n; i++ {
+		c, err := db.Conn(ctx)
+		if err != nil {
+			return errors.Wrap(err, "warmup")
+		}
+		conns = append(conns, c)
+		if err = c.PingContext(ctx); err != nil {
+			return errors.Wrap(err, "warmup")
+		}
+	}
+	return nil
+}
+
+// DSN returns the connection string including credentials. Use String for logging.
+func (c *Config) DSN() string {
+	if len(c.ConnectionString) > 0 || c.dialect == nil {
 		return c.ConnectionString
 	}
 	return c.dialect.BuildString(c.User, c.Pass, c.Host, c.Port, c.Schema)
 }
 
+func (c *Config) String() string {
+	if len(c.ConnectionString) > 0 || c.dialect == nil {
+		return dialects.RedactDSN(c.ConnectionString)
+	}
+	return c.dialect.Redacted(c.User, c.Pass, c.Host, c.Port, c.Schema)
+}
+
+// Validate reports every problem of the configuration at once as a dialects.MultiError.
+func (c *Config) Validate() error {
+	errs := dialects.MultiError{}
+	if c.dialect == nil {
+		errs.Add(ErrNoDialect)
+	} else if v, ok := c.dialect.(dialects.Validator); ok {
+		errs.Add(v.Validate())
+	}
+	if len(c.ConnectionString) > 0 && c.Host != "" && c.Host != defaultHost {
+		errs.Addf("both connection string and host %s are set", c.Host)
+	}
+	if c.Port < 0 || c.Port > 65535 {
+		errs.Addf("port %d is out of range", c.Port)
+	}
+	errs.Add(c.PoolConfig.Validate())
+	if c.StatementTimeout < 0 || c.LockTimeout < 0 {
+		errs.Addf("timeouts must not be negative")
+	}
+	if c.Log.SlowThreshold < 0 {
+		errs.Addf("slow threshold must not be negative")
+	}
+	return errs.Err()
+}
+
+func (c *Config) GoString() string {
+	name, dsn := "", dialects.RedactDSN(c.ConnectionString)
+	if c.dialect != nil {
+		name, dsn = c.dialect.Name(), c.String()
+	}
+	return fmt.Sprintf("&datasources.Config{Dialect:%q, DSN:%q, PoolConfig:%#v, Debug:%t}", name, dsn, c.PoolConfig, c.Debug)
+}
+
+func (c *Config) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Dialect          string     `json:"dialect,omitempty"`
+		User             string     `json:"user,omitempty"`
+		Pass             string     `json:"pass,omitempty"`
+		Host             string     `json:"host,omitempty"`
+		Port             int        `json:"port,omitempty"`
+		Schema           string     `json:"schema,omitempty"`
+		ConnectionString string     `json:"connection_string,omitempty"`
+		Pool             PoolConfig `json:"pool"`
+		Debug            bool       `json:"debug"`
+	}{
+		User:             c.User,
+		Pass:             dialects.Mask(c.Pass),
+		Host:             c.Host,
+		Port:             c.Port,
+		Schema:           c.Schema,
+		ConnectionString: dialects.RedactDSN(c.ConnectionString),
+		Pool:             c.PoolConfig,
+		Debug:            c.Debug,
+	}
+	if c.dialect != nil {
+		v.Dialect = c.dialect.Name()
+	}
+	return json.Marshal(v)
+}
+
 func (c *Config) Build() gorm.Dialector {
 	if len(c.ConnectionString) > 0 {
 		return c.dialect.BuildDialector(c.ConnectionString)
 	}
 	return c.dialect.Build(c.User, c.Pass, c.Host, c.Port, c.Schema)
 }
+
+// initStatements returns the timeout statements of the dialect followed by InitStatements.
+func (c *Config) initStatements() ([]string, error) {
+	stmts, err := c.timeoutStatements()
+	if err != nil {
+		return nil, err
+	}
+	return append(stmts, c.InitStatements...), nil
+}
+
+func (c *Config) BuildE(ctx context.Context) (gorm.Dialector, error) {
+	if c.dialect == nil {
+		return nil, ErrNoDialect
+	}
+	stmts, err := c.initStatements()
+	if err != nil {
+		return nil, err
+	}
+	session := len(stmts) > 0 || c.OnConnect != nil
+	if c.Credentials != nil {
+		connector, err := newCredentialConnector(c)
+		if err != nil {
+			return nil, err
+		}
+		dsn := c.dialect.BuildString(c.User, "", c.Host, c.Port, c.Schema)
+		if session {
+			return connector.builder.BuildConn(dsn, sql.OpenDB(&sessionConnector{Connector: connector, statements: stmts, onConnect: c.OnConnect})), nil
+		}
+		return connector.builder.BuildConn(dsn, sql.OpenDB(connector)), nil
+	}
+	if session {
+		builder, ok := c.dialect.(dialects.DriverBuilder)
+		if !ok {
+			return nil, errors.Errorf("%s does not support init statements", c.dialect.Name())
+		}
+		dsn := c.DSN()
+		connector, err := newConnector(builder, dsn)
+		if err != nil {
+			return nil, err
+		}
+		return builder.BuildConn(dsn, sql.OpenDB(&sessionConnector{Connector: connector, statements: stmts, onConnect: c.OnConnect})), nil
+	}
+	if len(c.ConnectionString) > 0 {
+		return c.dialect.BuildDialector(c.ConnectionString), nil
+	}
+	return c.dialect.BuildE(ctx, c.User, c.Pass, c.Host, c.Port, c.Schema)
+}
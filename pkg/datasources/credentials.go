@@ -0,0 +1,155 @@
+package datasources
+
+import (
+	"bytes"
+	"context"
+	"database/sql/driver"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/pkg/errors"
+)
+
+type Credentials struct {
+	User string
+	Pass string
+}
+
+// CredentialProvider is asked for credentials every time a new physical connection is opened.
+// An empty User falls back to Config.User.
+type CredentialProvider interface {
+	Credentials(ctx context.Context) (Credentials, error)
+}
+
+// Refresher is implemented by providers that cache credentials.
+// It is called once before retrying a connection rejected by the server.
+type Refresher interface {
+	Refresh(ctx context.Context) error
+}
+
+type CredentialFunc func(ctx context.Context) (Credentials, error)
+
+func (f CredentialFunc) Credentials(ctx context.Context) (Credentials, error) {
+	return f(ctx)
+}
+
+// FileCredentials reads the password from a file such as a mounted secret and re-reads it when the file changes.
+type FileCredentials struct {
+	User    string
+	Path    string
+	mu      sync.Mutex
+	pass    string
+	modTime time.Time
+	size    int64
+}
+
+func NewFileCredentials(user, path string) *FileCredentials {
+	return &FileCredentials{User: user, Path: path}
+}
+
+func (f *FileCredentials) Credentials(_ context.Context) (Credentials, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return Credentials{}, err
+	}
+	if f.modTime.IsZero() || !info.ModTime().Equal(f.modTime) || info.Size() != f.size {
+		if err = f.load(info); err != nil {
+			return Credentials{}, err
+		}
+	}
+	return Credentials{User: f.User, Pass: f.pass}, nil
+}
+
+func (f *FileCredentials) Refresh(_ context.Context) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	info, err := os.Stat(f.Path)
+	if err != nil {
+		return err
+	}
+	return f.load(info)
+}
+
+func (f *FileCredentials) load(info os.FileInfo) error {
+	data, err := os.ReadFile(f.Path)
+	if err != nil {
+		return err
+	}
+	f.pass = string(bytes.TrimRight(data, "\r\n"))
+	f.modTime = info.ModTime()
+	f.size = info.Size()
+	return nil
+}
+
+// credentialConnector builds a DSN with the current credentials for each new connection.
+type credentialConnector struct {
+	config   *Config
+	builder  dialects.DriverBuilder
+	provider CredentialProvider
+}
+
+func newCredentialConnector(config *Config) (*credentialConnector, error) {
+	if len(config.ConnectionString) > 0 {
+		return nil, errors.New("credential provider cannot be used with a connection string")
+	}
+	builder, ok := config.dialect.(dialects.DriverBuilder)
+	if !ok {
+		return nil, errors.Errorf("%s does not support credential providers", config.dialect.Name())
+	}
+	return &credentialConnector{
+		config:   config,
+		builder:  builder,
+		provider: config.Credentials,
+	}, nil
+}
+
+func (c *credentialConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.connect(ctx)
+	if err == nil || !c.isAuthFailure(err) {
+		return conn, err
+	}
+	logging.Info("authentication failed, refreshing credentials.")
+	if r, ok := c.provider.(Refresher); ok {
+		if rerr := r.Refresh(ctx); rerr != nil {
+			logging.Warn("%v", rerr)
+			return nil, err
+		}
+	}
+	return c.connect(ctx)
+}
+
+func (c *credentialConnector) connect(ctx context.Context) (driver.Conn, error) {
+	creds, err := c.provider.Credentials(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "credentials")
+	}
+	if creds.User == "" {
+		creds.User = c.config.User
+	}
+	dsn := c.config.dialect.BuildString(creds.User, creds.Pass, c.config.Host, c.config.Port, c.config.Schema)
+	d := c.builder.Driver()
+	if dc, ok := d.(driver.DriverContext); ok {
+		connector, err := dc.OpenConnector(dsn)
+		if err != nil {
+			return nil, err
+		}
+		return connector.Connect(ctx)
+	}
+	return d.Open(dsn)
+}
+
+func (c *credentialConnector) isAuthFailure(err error) bool {
+	if v, ok := c.config.dialect.(dialects.AuthErrors); ok {
+		return v.IsAuthFailure(err)
+	}
+	return false
+}
+
+func (c *credentialConnector) Driver() driver.Driver {
+	return c.builder.Driver()
+}
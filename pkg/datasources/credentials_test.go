@@ -0,0 +1,162 @@
+package datasources
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+var errAuth = errors.New("authentication failed")
+var errRefused = errors.New("connection refused")
+
+// credDriver accepts connections whose DSN carries password and records every DSN it is opened with.
+type credDriver struct {
+	password string
+	err      error
+	dsns     []string
+}
+
+func (d *credDriver) Open(dsn string) (driver.Conn, error) {
+	d.dsns = append(d.dsns, dsn)
+	if d.err != nil {
+		return nil, d.err
+	}
+	if !strings.Contains(dsn, ":"+d.password+"@") {
+		return nil, errAuth
+	}
+	return credConn{}, nil
+}
+
+type credConn struct{}
+
+func (credConn) Prepare(query string) (driver.Stmt, error) { return nil, errRefused }
+func (credConn) Close() error                              { return nil }
+func (credConn) Begin() (driver.Tx, error)                 { return nil, errRefused }
+
+type credBuilder struct {
+	fileBuilder
+	driver *credDriver
+}
+
+func (b *credBuilder) BuildString(user, password, host string, port int, dbname string) string {
+	return user + ":" + password + "@" + host + "/" + dbname
+}
+func (b *credBuilder) Driver() driver.Driver                             { return b.driver }
+func (b *credBuilder) BuildConn(dsn string, conn *sql.DB) gorm.Dialector { return nil }
+func (b *credBuilder) IsAuthFailure(err error) bool                      { return errors.Is(err, errAuth) }
+
+// rotating returns pass until it is refreshed and next afterwards.
+type rotating struct {
+	pass, next string
+	refreshes  int
+}
+
+func (r *rotating) Credentials(_ context.Context) (Credentials, error) {
+	return Credentials{Pass: r.pass}, nil
+}
+
+func (r *rotating) Refresh(_ context.Context) error {
+	r.refreshes++
+	r.pass = r.next
+	return nil
+}
+
+func credConfig(d *credDriver, provider CredentialProvider) *Config {
+	return (&Config{User: "app", Host: "db", Schema: "app", Credentials: provider}).Dialect(&credBuilder{driver: d})
+}
+
+func TestFileCredentials(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "password")
+	if err := os.WriteFile(path, []byte("first\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d := &credDriver{password: "first"}
+	c, err := newCredentialConnector(credConfig(d, NewFileCredentials("", path)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(path, []byte("rotated\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d.password = "rotated"
+	if _, err = c.Connect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "app:first@db/app app:rotated@db/app", strings.Join(d.dsns, " "); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
+
+func TestCredentialRetry(t *testing.T) {
+	tests := []struct {
+		name      string
+		driver    *credDriver
+		dsns      string
+		refreshes int
+		err       error
+	}{
+		{name: "rotated", driver: &credDriver{password: "new"}, dsns: "app:old@db/app app:new@db/app", refreshes: 1},
+		{name: "still rejected", driver: &credDriver{password: "other"}, dsns: "app:old@db/app app:new@db/app", refreshes: 1, err: errAuth},
+		{name: "not an auth failure", driver: &credDriver{err: errRefused}, dsns: "app:old@db/app", err: errRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &rotating{pass: "old", next: "new"}
+			c, err := newCredentialConnector(credConfig(tt.driver, provider))
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, err = c.Connect(context.Background())
+			if !errors.Is(err, tt.err) || (tt.err == nil && err != nil) {
+				t.Errorf("expected=%v, actual=%v", tt.err, err)
+			}
+			if actual := strings.Join(tt.driver.dsns, " "); tt.dsns != actual {
+				t.Errorf("expected=%s, actual=%s", tt.dsns, actual)
+			}
+			if tt.refreshes != provider.refreshes {
+				t.Errorf("expected=%d, actual=%d", tt.refreshes, provider.refreshes)
+			}
+		})
+	}
+}
+
+func TestCredentialFunc(t *testing.T) {
+	calls := 0
+	d := &credDriver{password: "secret"}
+	c, err := newCredentialConnector(credConfig(d, CredentialFunc(func(ctx context.Context) (Credentials, error) {
+		calls++
+		return Credentials{User: "rotator", Pass: "secret"}, nil
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err = c.Connect(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if calls != 2 || d.dsns[1] != "rotator:secret@db/app" {
+		t.Errorf("expected=2 rotator:secret@db/app, actual=%d %v", calls, d.dsns)
+	}
+}
+
+func TestCredentialsWithConnectionString(t *testing.T) {
+	config := credConfig(&credDriver{}, &rotating{})
+	config.ConnectionString = "app:pass@db/app"
+	if _, err := newCredentialConnector(config); err == nil {
+		t.Errorf("expected an error for a connection string")
+	}
+	if _, err := config.BuildE(context.Background()); err == nil {
+		t.Errorf("expected an error for a connection string")
+	}
+}
@@ -1,57 +1,93 @@
 package datasources
 
 import (
-	"time"
+	"context"
+	"database/sql"
 
-	"github.com/goccha/envar/pkg/log"
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 )
 
-func connect(dialect gorm.Dialector, config *gorm.Config) (*gorm.DB, error) {
-	d := 1 * time.Second
-	timeout := true
-	var conn *gorm.DB
-	count := 20
-	for count > 0 {
-		c, err := gorm.Open(dialect, config)
-		if err != nil {
-			log.Info("%v", err)
-			time.Sleep(d)
-		} else {
-			timeout = false
-			conn = c
-			log.Info("connection established.")
-			break
+// connect opens dialect, rebuilding it for every retry because gorm closes the connection pool of a failed dialector.
+// rebuild runs with dialects.SingleAttempt so that policy is the only retry loop.
+func connect(ctx context.Context, dialect gorm.Dialector, rebuild func(ctx context.Context) (gorm.Dialector, error), config *gorm.Config, policy *dialects.RetryPolicy) (conn *gorm.DB, err error) {
+	err = policy.Do(ctx, func(ctx context.Context) (err error) {
+		if dialect == nil {
+			if dialect, err = rebuild(dialects.SingleAttempt(ctx)); err != nil {
+				var ce *dialects.ConnectError
+				if errors.As(err, &ce) {
+					return ce.Err
+				}
+				return
+			}
 		}
-		count--
-	}
-	if timeout {
-		return nil, errors.New("ping timeout")
+		conn, err = gorm.Open(dialect, config)
+		dialect = nil
+		if err != nil && conn != nil {
+			// gorm only closes the pool when Initialize fails, not when the ping does.
+			if sqlDB, derr := conn.DB(); derr == nil {
+				_ = sqlDB.Close()
+			}
+			conn = nil
+		}
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
+	logging.Info("connection established.")
 	return conn, nil
 }
 
-func newDB(config *Config) *gorm.DB {
+func newDB(ctx context.Context, config *Config) (*gorm.DB, error) {
+	if config.dialect == nil {
+		return nil, ErrNoDialect
+	}
 	dialect := config.dialect.Name()
-	log.Info("newConnection(" + dialect + ")")
-	log.Debug(config.String())
-	db, err := connect(config.Build(), &config.Config)
+	logging.Info("newConnection(" + dialect + ")")
+	logging.Debug("%s", config)
+	dialector, err := config.BuildE(dialects.SingleAttempt(ctx))
 	if err != nil {
-		panic(err)
+		var ce *dialects.ConnectError
+		if !errors.As(err, &ce) {
+			return nil, errors.Wrapf(err, "build %s dialector", dialect)
+		}
+		dialector = nil // connect retries the build
+	}
+	if config.Logger == nil {
+		config.Logger = config.Log.logger()
 	}
-	sqlDb, err := db.DB()
+	db, err := connect(ctx, dialector, config.BuildE, &config.Config, config.retryPolicy())
 	if err != nil {
-		panic(err)
+		return nil, err
 	}
 	if config.Debug {
 		db.Logger = db.Logger.LogMode(logger.Info)
 	}
-	sqlDb.SetMaxIdleConns(config.MaxIdleConns)
-	sqlDb.SetMaxOpenConns(config.MaxOpenConns)
-	sqlDb.SetConnMaxLifetime(config.ConnMaxLifetime)
-	return db
+	if err = config.useTimeouts(db); err != nil {
+		Close(db)
+		return nil, err
+	}
+	if config.Tracing != nil {
+		if err = db.Use(config.Tracing); err != nil {
+			Close(db)
+			return nil, err
+		}
+	}
+	if config.SQLComment != nil {
+		if err = db.Use(config.SQLComment); err != nil {
+			Close(db)
+			return nil, err
+		}
+	}
+	if err = config.PoolConfig.Apply(ctx, db); err != nil {
+		Close(db)
+		return nil, err
+	}
+	return db, nil
 }
 
 type DataSource struct {
@@ -61,20 +97,54 @@ type DataSource struct {
 func (ds *DataSource) GetConnection() *gorm.DB {
 	return ds.db
 }
+
+func (ds *DataSource) Stats() sql.DBStats {
+	if sqlDb, err := ds.db.DB(); err == nil {
+		return sqlDb.Stats()
+	}
+	return sql.DBStats{}
+}
 func NewDataSource(c *Config) *DataSource {
+	return NewDataSourceContext(context.Background(), c)
+}
+
+func NewDataSourceContext(ctx context.Context, c *Config) *DataSource {
+	ds, err := NewDataSourceE(ctx, c)
+	if err != nil {
+		panic(err)
+	}
+	return ds
+}
+
+func NewDataSourceE(ctx context.Context, c *Config) (*DataSource, error) {
 	if c == nil {
 		c = &Config{}
 	}
-	return &DataSource{newDB(c)}
+	db, err := newDB(ctx, c)
+	if err != nil {
+		return nil, err
+	}
+	return &DataSource{db}, nil
+}
+
+// Connector opens c lazily, e.g. as a replicas.Connector.
+func (c *Config) Connector(ctx context.Context) func() (*gorm.DB, error) {
+	return func() (*gorm.DB, error) {
+		ds, err := NewDataSourceE(ctx, c)
+		if err != nil {
+			return nil, err
+		}
+		return ds.GetConnection(), nil
+	}
 }
 
 func Close(db *gorm.DB) {
 	if db != nil {
 		if sqlDB, err := db.DB(); err != nil {
-			log.Warn("%v", err)
+			logging.Warn("%v", err)
 		} else {
 			if err := sqlDB.Close(); err != nil {
-				log.Warn("%v", err)
+				logging.Warn("%v", err)
 			}
 		}
 	}
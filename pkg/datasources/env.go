@@ -3,36 +3,228 @@ package datasources
 import (
 	"github.com/goccha/envar"
 	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/sqlcomment"
+	"github.com/pkg/errors"
+	"strconv"
 	"time"
 )
 
 type Env struct {
-	User             string
-	Pass             string
-	Host             string
-	Port             string
-	Schema           string
-	ConnectionString string
-	MaxIdleConns     string
-	MaxOpenConns     string
-	ConnMaxLifetime  string
-	Debug            string
+	User                 string
+	Pass                 string
+	PassFile             string
+	Host                 string
+	Port                 string
+	Schema               string
+	ConnectionString     string
+	MaxIdleConns         string
+	MaxOpenConns         string
+	ConnMaxLifetime      string
+	ConnMaxIdleTime      string
+	MinIdleConns         string
+	Debug                string
+	SlowThreshold        string
+	StatementTimeout     string
+	SQLComment           string
+	LockTimeout          string
+	IgnoreRecordNotFound string
+	ParameterizedQueries string
+	prefix               string
+	scoped               bool
+}
+
+// EnvWithPrefix derives every key from prefix, e.g. BILLING_DB_USER or BILLING_DB_MAX_OPEN_CONNECTIONS.
+// Unlike Env, unset keys do not fall back to the DB_* names.
+func EnvWithPrefix(prefix string) *Env {
+	return &Env{
+		User:                 dialects.EnvKey(prefix, "USER"),
+		Pass:                 dialects.EnvKey(prefix, "PASSWORD"),
+		PassFile:             dialects.EnvKey(prefix, "PASSWORD_FILE"),
+		Host:                 dialects.EnvKey(prefix, "HOST"),
+		Port:                 dialects.EnvKey(prefix, "PORT"),
+		Schema:               dialects.EnvKey(prefix, "SCHEMA"),
+		ConnectionString:     dialects.EnvKey(prefix, "CONNECT_URL"),
+		MaxIdleConns:         dialects.EnvKey(prefix, "MAX_IDLE_CONNECTIONS"),
+		MaxOpenConns:         dialects.EnvKey(prefix, "MAX_OPEN_CONNECTIONS"),
+		ConnMaxLifetime:      dialects.EnvKey(prefix, "CONNECTION_MAX_LIFETIME"),
+		ConnMaxIdleTime:      dialects.EnvKey(prefix, "CONNECTION_MAX_IDLE_TIME"),
+		MinIdleConns:         dialects.EnvKey(prefix, "MIN_IDLE_CONNECTIONS"),
+		Debug:                dialects.EnvKey(prefix, "LOG_MODE"),
+		SlowThreshold:        dialects.EnvKey(prefix, "SLOW_THRESHOLD"),
+		StatementTimeout:     dialects.EnvKey(prefix, "STATEMENT_TIMEOUT"),
+		SQLComment:           dialects.EnvKey(prefix, "SQL_COMMENT"),
+		LockTimeout:          dialects.EnvKey(prefix, "LOCK_TIMEOUT"),
+		IgnoreRecordNotFound: dialects.EnvKey(prefix, "IGNORE_RECORD_NOT_FOUND"),
+		ParameterizedQueries: dialects.EnvKey(prefix, "PARAMETERIZED_QUERIES"),
+		prefix:               prefix,
+		scoped:               true,
+	}
+}
+
+// Replica returns the Env of the i-th replica, e.g. BILLING_DB_REPLICA_0_HOST, or DB_REPLICA_0_HOST without a prefix.
+func (e *Env) Replica(i int) *Env {
+	prefix := e.prefix
+	if !e.scoped {
+		prefix = "DB"
+	}
+	return EnvWithPrefix(dialects.EnvKey(prefix, "REPLICA_"+strconv.Itoa(i)))
+}
+
+// Keys lists the variables that e reads.
+func (e *Env) Keys() []string {
+	pairs := [][2]string{
+		{e.ConnectionString, "DB_CONNECT_URL"},
+		{e.User, "DB_USER"},
+		{e.Pass, "DB_PASSWORD"},
+		{e.PassFile, "DB_PASSWORD_FILE"},
+		{e.Host, "DB_HOST"},
+		{e.Port, "DB_PORT"},
+		{e.Schema, "DB_SCHEMA"},
+		{e.MaxIdleConns, "DB_MAX_IDLE_CONNECTIONS"},
+		{e.MaxOpenConns, "DB_MAX_OPEN_CONNECTIONS"},
+		{e.ConnMaxLifetime, "DB_CONNECTION_MAX_LIFETIME"},
+		{e.ConnMaxIdleTime, "DB_CONNECTION_MAX_IDLE_TIME"},
+		{e.MinIdleConns, "DB_MIN_IDLE_CONNECTIONS"},
+		{e.StatementTimeout, "DB_STATEMENT_TIMEOUT"},
+		{e.LockTimeout, "DB_LOCK_TIMEOUT"},
+		{e.SQLComment, "DB_SQL_COMMENT"},
+		{e.Debug, "GORM_LOG_MODE"},
+		{e.SlowThreshold, "GORM_SLOW_THRESHOLD"},
+		{e.IgnoreRecordNotFound, "GORM_IGNORE_RECORD_NOT_FOUND"},
+		{e.ParameterizedQueries, "GORM_PARAMETERIZED_QUERIES"},
+	}
+	keys := make([]string, 0, len(pairs))
+	for _, p := range pairs {
+		for _, k := range e.keys(p[0], p[1]) {
+			if k != "" {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys
+}
+
+func (e *Env) keys(key, fallback string) []string {
+	if e.scoped {
+		return []string{key}
+	}
+	return []string{key, fallback}
+}
+
+// defaultHost is not treated as a conflict with a connection string.
+const defaultHost = "127.0.0.1"
+
+func defaultConfig() *Config {
+	return &Config{
+		Host: defaultHost,
+		PoolConfig: PoolConfig{
+			MaxIdleConns:    10,
+			MaxOpenConns:    50,
+			ConnMaxLifetime: time.Hour,
+		},
+	}
 }
 
 func (e *Env) Build(builder dialects.Builder) *Config {
-	config := &Config{}
-	config.ConnectionString = envar.String(e.ConnectionString, "DB_CONNECT_URL")
+	config := e.Apply(defaultConfig())
+	config.Dialect(builder)
+	return config
+}
+
+// BuildStrict is Build that fails on malformed variables and on an invalid Config, reporting every problem at once.
+func (e *Env) BuildStrict(builder dialects.Builder) (*Config, error) {
+	errs := dialects.MultiError{}
+	errs.Add(e.Validate())
+	config := e.Build(builder)
+	errs.Add(config.Validate())
+	return config, errs.Err()
+}
+
+// Validate checks that the variables which are set can be parsed.
+func (e *Env) Validate() error {
+	errs := dialects.MultiError{}
+	if envar.Has(e.keys(e.ConnectionString, "DB_CONNECT_URL")...) && envar.Has(e.keys(e.Host, "DB_HOST")...) {
+		errs.Addf("both connect url and host are set")
+	}
+	errs.CheckInt(e.keys(e.Port, "DB_PORT")...)
+	errs.CheckInt(e.keys(e.MaxIdleConns, "DB_MAX_IDLE_CONNECTIONS")...)
+	errs.CheckInt(e.keys(e.MaxOpenConns, "DB_MAX_OPEN_CONNECTIONS")...)
+	errs.CheckDuration(e.keys(e.ConnMaxLifetime, "DB_CONNECTION_MAX_LIFETIME")...)
+	errs.CheckDuration(e.keys(e.ConnMaxIdleTime, "DB_CONNECTION_MAX_IDLE_TIME")...)
+	errs.CheckInt(e.keys(e.MinIdleConns, "DB_MIN_IDLE_CONNECTIONS")...)
+	errs.CheckDuration(e.keys(e.StatementTimeout, "DB_STATEMENT_TIMEOUT")...)
+	errs.CheckDuration(e.keys(e.LockTimeout, "DB_LOCK_TIMEOUT")...)
+	errs.CheckBool(e.keys(e.SQLComment, "DB_SQL_COMMENT")...)
+	errs.CheckBool(e.keys(e.Debug, "GORM_LOG_MODE")...)
+	errs.CheckDuration(e.keys(e.SlowThreshold, "GORM_SLOW_THRESHOLD")...)
+	errs.CheckBool(e.keys(e.IgnoreRecordNotFound, "GORM_IGNORE_RECORD_NOT_FOUND")...)
+	errs.CheckBool(e.keys(e.ParameterizedQueries, "GORM_PARAMETERIZED_QUERIES")...)
+	return errs.Err()
+}
+
+// BuildReplicas creates a Config for each consecutively numbered replica that has a host or connect url set.
+// Unset values are inherited from primary.
+func (e *Env) BuildReplicas(primary *Config) []*Config {
+	configs := make([]*Config, 0)
+	for i := 0; ; i++ {
+		re := e.Replica(i)
+		if !envar.Has(re.Host) && !envar.Has(re.ConnectionString) {
+			break
+		}
+		config := *primary
+		config.ConnectionString = ""
+		configs = append(configs, re.Apply(&config))
+	}
+	return configs
+}
+
+// Load creates a Config from DB_CONNECT_URL alone, choosing the dialect by its scheme. See ParseURL.
+func (e *Env) Load(options ...dialects.Option) (*Config, error) {
+	raw := envar.String(e.keys(e.ConnectionString, "DB_CONNECT_URL")...)
+	if raw == "" {
+		return nil, errors.New("DB_CONNECT_URL is not set")
+	}
+	config, err := ParseURL(raw, options...)
+	if err != nil {
+		return nil, err
+	}
+	return e.applyPool(config), nil
+}
+
+// Apply overrides the values of config with the environment variables that are set.
+func (e *Env) Apply(config *Config) *Config {
+	config.ConnectionString = envar.Get(e.keys(e.ConnectionString, "DB_CONNECT_URL")...).String(config.ConnectionString)
 	if len(config.ConnectionString) == 0 {
-		config.User = envar.String(e.User, "DB_USER")
-		config.Pass = envar.String(e.Pass, "DB_PASSWORD")
-		config.Host = envar.Get(e.Host, "DB_HOST").String("127.0.0.1")
-		config.Port = envar.Get(e.Port, "DB_PORT").Int(0)
-		config.Schema = envar.String(e.Schema, "DB_SCHEMA")
+		config.User = envar.Get(e.keys(e.User, "DB_USER")...).String(config.User)
+		config.Pass = envar.Get(e.keys(e.Pass, "DB_PASSWORD")...).String(config.Pass)
+		if path := envar.String(e.keys(e.PassFile, "DB_PASSWORD_FILE")...); path != "" {
+			config.Credentials = NewFileCredentials("", path)
+		}
+		config.Host = envar.Get(e.keys(e.Host, "DB_HOST")...).String(config.Host)
+		config.Port = envar.Get(e.keys(e.Port, "DB_PORT")...).Int(config.Port)
+		config.Schema = envar.Get(e.keys(e.Schema, "DB_SCHEMA")...).String(config.Schema)
 	}
-	config.Dialect(builder)
-	config.MaxIdleConns = envar.Get(e.MaxIdleConns, "DB_MAX_IDLE_CONNECTIONS").Int(10)
-	config.MaxOpenConns = envar.Get(e.MaxOpenConns, "DB_MAX_OPEN_CONNECTIONS").Int(50)
-	config.ConnMaxLifetime = envar.Get(e.ConnMaxLifetime, "DB_CONNECTION_MAX_LIFETIME").Duration(time.Hour)
-	config.Debug = envar.Get(e.Debug, "GORM_LOG_MODE").Bool(false)
+	return e.applyPool(config)
+}
+
+func (e *Env) applyPool(config *Config) *Config {
+	config.MaxIdleConns = envar.Get(e.keys(e.MaxIdleConns, "DB_MAX_IDLE_CONNECTIONS")...).Int(config.MaxIdleConns)
+	config.MaxOpenConns = envar.Get(e.keys(e.MaxOpenConns, "DB_MAX_OPEN_CONNECTIONS")...).Int(config.MaxOpenConns)
+	config.ConnMaxLifetime = envar.Get(e.keys(e.ConnMaxLifetime, "DB_CONNECTION_MAX_LIFETIME")...).Duration(config.ConnMaxLifetime)
+	config.ConnMaxIdleTime = envar.Get(e.keys(e.ConnMaxIdleTime, "DB_CONNECTION_MAX_IDLE_TIME")...).Duration(config.ConnMaxIdleTime)
+	config.MinIdleConns = envar.Get(e.keys(e.MinIdleConns, "DB_MIN_IDLE_CONNECTIONS")...).Int(config.MinIdleConns)
+	config.StatementTimeout = envar.Get(e.keys(e.StatementTimeout, "DB_STATEMENT_TIMEOUT")...).Duration(config.StatementTimeout)
+	config.LockTimeout = envar.Get(e.keys(e.LockTimeout, "DB_LOCK_TIMEOUT")...).Duration(config.LockTimeout)
+	if v := envar.Get(e.keys(e.SQLComment, "DB_SQL_COMMENT")...); v.Has() {
+		if !v.Bool(false) {
+			config.SQLComment = nil
+		} else if config.SQLComment == nil {
+			config.SQLComment = sqlcomment.New()
+		}
+	}
+	config.Debug = envar.Get(e.keys(e.Debug, "GORM_LOG_MODE")...).Bool(config.Debug)
+	config.Log.SlowThreshold = envar.Get(e.keys(e.SlowThreshold, "GORM_SLOW_THRESHOLD")...).Duration(config.Log.SlowThreshold)
+	config.Log.IgnoreRecordNotFoundError = envar.Get(e.keys(e.IgnoreRecordNotFound, "GORM_IGNORE_RECORD_NOT_FOUND")...).Bool(config.Log.IgnoreRecordNotFoundError)
+	config.Log.ParameterizedQueries = envar.Get(e.keys(e.ParameterizedQueries, "GORM_PARAMETERIZED_QUERIES")...).Bool(config.Log.ParameterizedQueries)
 	return config
 }
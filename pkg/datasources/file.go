@@ -0,0 +1,241 @@
+package datasources
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	FormatYAML = "yaml"
+	FormatJSON = "json"
+	FormatTOML = "toml"
+)
+
+// Document is the file representation of a datasource and its replicas.
+//
+//	dialect: mysql
+//	host: db.internal
+//	user: app
+//	schema: app
+//	options:
+//	  charset: utf8mb4
+//	pool:
+//	  max_open_conns: 50
+//	  conn_max_lifetime: 1h
+//	replicas:
+//	  - host: replica-0.internal
+type Document struct {
+	Dialect string `json:"dialect"`
+	Connection
+	Pool     PoolDocument `json:"pool"`
+	Debug    *bool        `json:"debug,omitempty"`
+	Replicas []Connection `json:"replicas,omitempty"`
+}
+
+type Connection struct {
+	User             string                 `json:"user,omitempty"`
+	Pass             string                 `json:"pass,omitempty"`
+	Host             string                 `json:"host,omitempty"`
+	Port             int                    `json:"port,omitempty"`
+	Schema           string                 `json:"schema,omitempty"`
+	ConnectionString string                 `json:"connection_string,omitempty"`
+	Options          map[string]interface{} `json:"options,omitempty"`
+}
+
+type PoolDocument struct {
+	MaxIdleConns    *int      `json:"max_idle_conns,omitempty"`
+	MaxOpenConns    *int      `json:"max_open_conns,omitempty"`
+	ConnMaxLifetime *Duration `json:"conn_max_lifetime,omitempty"`
+	ConnMaxIdleTime *Duration `json:"conn_max_idle_time,omitempty"`
+	MinIdleConns    *int      `json:"min_idle_conns,omitempty"`
+}
+
+// Duration accepts time.ParseDuration strings such as "30s" or "1h".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
+}
+
+// LoadFile reads a Document, choosing the format from the file extension.
+func LoadFile(path string) (*Document, error) {
+	var format string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		format = FormatYAML
+	case ".json":
+		format = FormatJSON
+	case ".toml":
+		format = FormatTOML
+	default:
+		return nil, errors.Errorf("unsupported config file: %s", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		_ = f.Close()
+	}()
+	doc, err := Decode(f, format)
+	if err != nil {
+		return nil, errors.Wrap(err, path)
+	}
+	return doc, nil
+}
+
+func Decode(r io.Reader, format string) (*Document, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if format != FormatJSON {
+		// yaml and toml are normalized to json so that a single set of field names applies.
+		var m map[string]interface{}
+		switch format {
+		case FormatYAML:
+			err = yaml.Unmarshal(data, &m)
+		case FormatTOML:
+			err = toml.Unmarshal(data, &m)
+		default:
+			return nil, errors.Errorf("unsupported format: %s", format)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if data, err = json.Marshal(m); err != nil {
+			return nil, err
+		}
+	}
+	doc := &Document{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	dec.UseNumber() // keeps large integer options such as maxAllowedPacket out of exponent notation
+	if err = dec.Decode(doc); err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
+
+func (c Connection) params() map[string]string {
+	params := make(map[string]string, len(c.Options))
+	for k, v := range c.Options {
+		params[k] = fmt.Sprint(v)
+	}
+	return params
+}
+
+// Config builds the primary Config. Environment variables read through env override file values, and so do the
+// dialect variables with the same prefix, e.g. MYSQL_CHARSET or BILLING_MYSQL_CHARSET for EnvWithPrefix("BILLING").
+// options are applied to the dialect Builder after them.
+func (d *Document) Config(env *Env, options ...dialects.Option) (*Config, error) {
+	if env == nil {
+		env = &Env{}
+	}
+	config := defaultConfig()
+	d.Connection.apply(config)
+	d.applyPool(config)
+	builder, err := d.builder(env, d.Connection.params(), options)
+	if err != nil {
+		return nil, err
+	}
+	return env.Apply(config).Dialect(builder), nil
+}
+
+// ReplicaConfigs builds a Config per replica. Unset fields are inherited from the primary,
+// and the replica environment variables, e.g. DB_REPLICA_0_HOST, override the file values.
+func (d *Document) ReplicaConfigs(env *Env, options ...dialects.Option) ([]*Config, error) {
+	if env == nil {
+		env = &Env{}
+	}
+	primary, err := d.Config(env, options...)
+	if err != nil {
+		return nil, err
+	}
+	configs := make([]*Config, 0, len(d.Replicas))
+	for i, r := range d.Replicas {
+		params := d.Connection.params()
+		for k, v := range r.params() {
+			params[k] = v
+		}
+		builder, err := d.builder(env, params, options)
+		if err != nil {
+			return nil, errors.Wrapf(err, "replicas[%d]", i)
+		}
+		config := *primary
+		config.ConnectionString = ""
+		r.apply(&config)
+		configs = append(configs, env.Replica(i).Apply(&config).Dialect(builder))
+	}
+	return configs, nil
+}
+
+// builder creates the dialect Builder from params and applies the dialect environment variables before options.
+func (d *Document) builder(env *Env, params map[string]string, options []dialects.Option) (dialects.Builder, error) {
+	if opt := dialects.EnvOption(d.Dialect, env.prefix); opt != nil {
+		options = append([]dialects.Option{opt}, options...)
+	}
+	return dialects.New(d.Dialect, params, options...)
+}
+
+func (c Connection) apply(config *Config) {
+	if c.User != "" {
+		config.User = c.User
+	}
+	if c.Pass != "" {
+		config.Pass = c.Pass
+	}
+	if c.Host != "" {
+		config.Host = c.Host
+	}
+	if c.Port > 0 {
+		config.Port = c.Port
+	}
+	if c.Schema != "" {
+		config.Schema = c.Schema
+	}
+	if c.ConnectionString != "" {
+		config.ConnectionString = c.ConnectionString
+	}
+}
+
+func (d *Document) applyPool(config *Config) {
+	if d.Pool.MaxIdleConns != nil {
+		config.MaxIdleConns = *d.Pool.MaxIdleConns
+	}
+	if d.Pool.MaxOpenConns != nil {
+		config.MaxOpenConns = *d.Pool.MaxOpenConns
+	}
+	if d.Pool.ConnMaxLifetime != nil {
+		config.ConnMaxLifetime = time.Duration(*d.Pool.ConnMaxLifetime)
+	}
+	if d.Pool.ConnMaxIdleTime != nil {
+		config.ConnMaxIdleTime = time.Duration(*d.Pool.ConnMaxIdleTime)
+	}
+	if d.Pool.MinIdleConns != nil {
+		config.MinIdleConns = *d.Pool.MinIdleConns
+	}
+	if d.Debug != nil {
+		config.Debug = *d.Debug
+	}
+}
@@ -0,0 +1,168 @@
+package datasources
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/dialects"
+	"gorm.io/gorm"
+)
+
+// fileBuilder records the parameters it was created with.
+type fileBuilder struct {
+	params map[string]string
+}
+
+func (b *fileBuilder) Name() string { return "filetest" }
+func (b *fileBuilder) Build(user, password, host string, port int, dbname string) gorm.Dialector {
+	return nil
+}
+func (b *fileBuilder) BuildE(ctx context.Context, user, password, host string, port int, dbname string) (gorm.Dialector, error) {
+	return nil, nil
+}
+func (b *fileBuilder) BuildString(user, password, host string, port int, dbname string) string {
+	return user + "@" + host + "/" + dbname
+}
+func (b *fileBuilder) Redacted(user, password, host string, port int, dbname string) string {
+	return b.BuildString(user, password, host, port, dbname)
+}
+func (b *fileBuilder) BuildDialector(url string) gorm.Dialector { return nil }
+
+func init() {
+	dialects.Register(func(params map[string]string) (dialects.Builder, error) {
+		return &fileBuilder{params: params}, nil
+	}, "filetest")
+	dialects.RegisterEnv(func(prefix string) dialects.Option {
+		return func(b dialects.Builder) {
+			if v := os.Getenv(dialects.EnvKey(prefix, "FILETEST_CHARSET")); v != "" {
+				b.(*fileBuilder).params["charset"] = v
+			}
+		}
+	}, "filetest")
+}
+
+func TestDecode(t *testing.T) {
+	tests := []struct {
+		format string
+		data   string
+	}{
+		{format: FormatYAML, data: `
+dialect: filetest
+host: db.internal
+user: app
+options:
+  maxAllowedPacket: 67108864
+  ratio: 0.5
+  parseTime: true
+pool:
+  max_open_conns: 5
+  conn_max_lifetime: 1h
+`},
+		{format: FormatJSON, data: `{"dialect": "filetest", "host": "db.internal", "user": "app",
+"options": {"maxAllowedPacket": 67108864, "ratio": 0.5, "parseTime": true},
+"pool": {"max_open_conns": 5, "conn_max_lifetime": "1h"}}`},
+		{format: FormatTOML, data: `
+dialect = "filetest"
+host = "db.internal"
+user = "app"
+[options]
+maxAllowedPacket = 67108864
+ratio = 0.5
+parseTime = true
+[pool]
+max_open_conns = 5
+conn_max_lifetime = "1h"
+`},
+	}
+	expected := map[string]string{"maxAllowedPacket": "67108864", "ratio": "0.5", "parseTime": "true"}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			doc, err := Decode(strings.NewReader(tt.data), tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			config, err := doc.Config(EnvWithPrefix("FILETEST_DECODE"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := config.Builder().(*fileBuilder).params
+			for k, v := range expected {
+				if params[k] != v {
+					t.Errorf("%s: expected=%s, actual=%s", k, v, params[k])
+				}
+			}
+			if config.Host != "db.internal" || config.MaxOpenConns != 5 || config.ConnMaxLifetime != time.Hour {
+				t.Errorf("expected=db.internal/5/1h, actual=%s/%d/%v", config.Host, config.MaxOpenConns, config.ConnMaxLifetime)
+			}
+		})
+	}
+	if _, err := Decode(strings.NewReader(`{"dialect": "filetest", "hostname": "x"}`), FormatJSON); err == nil {
+		t.Errorf("expected an error for an unknown field")
+	}
+	if _, err := Decode(strings.NewReader(`dialect = "filetest"`), "ini"); err == nil {
+		t.Errorf("expected an error for an unsupported format")
+	}
+}
+
+func TestReplicaConfigs(t *testing.T) {
+	doc, err := Decode(strings.NewReader(`
+dialect: filetest
+host: primary.internal
+user: app
+schema: app
+options:
+  charset: utf8mb4
+  timeout: 5s
+pool:
+  max_open_conns: 5
+replicas:
+  - host: replica-0.internal
+    options:
+      timeout: 1s
+  - host: replica-1.internal
+    user: reader
+`), FormatYAML)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("FILETEST_REPLICAS_MAX_OPEN_CONNECTIONS", "99")
+	t.Setenv("FILETEST_REPLICAS_REPLICA_1_HOST", "replica-1.override")
+	t.Setenv("FILETEST_REPLICAS_FILETEST_CHARSET", "latin1")
+	env := EnvWithPrefix("FILETEST_REPLICAS")
+	primary, err := doc.Config(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs, err := doc.ReplicaConfigs(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		config   *Config
+		dsn      string
+		timeout  string
+		maxOpens int
+	}{
+		{config: primary, dsn: "app@primary.internal/app", timeout: "5s", maxOpens: 99},
+		{config: configs[0], dsn: "app@replica-0.internal/app", timeout: "1s", maxOpens: 99},
+		{config: configs[1], dsn: "reader@replica-1.override/app", timeout: "5s", maxOpens: 99},
+	}
+	if len(configs) != 2 {
+		t.Fatalf("expected=2, actual=%d", len(configs))
+	}
+	for _, tt := range tests {
+		if actual := tt.config.DSN(); tt.dsn != actual {
+			t.Errorf("expected=%s, actual=%s", tt.dsn, actual)
+		}
+		params := tt.config.Builder().(*fileBuilder).params
+		if params["timeout"] != tt.timeout || params["charset"] != "latin1" {
+			t.Errorf("expected=%s, actual=%v", tt.timeout, params)
+		}
+		if tt.config.MaxOpenConns != tt.maxOpens {
+			t.Errorf("expected=%d, actual=%d", tt.maxOpens, tt.config.MaxOpenConns)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package datasources
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type HealthStatus string
+
+const (
+	StatusUp       HealthStatus = "up"
+	StatusDegraded HealthStatus = "degraded"
+	StatusDown     HealthStatus = "down"
+)
+
+type PoolStats struct {
+	MaxOpenConnections int           `json:"max_open_connections"`
+	OpenConnections    int           `json:"open_connections"`
+	InUse              int           `json:"in_use"`
+	Idle               int           `json:"idle"`
+	WaitCount          int64         `json:"wait_count"`
+	WaitDuration       time.Duration `json:"wait_duration"`
+	MaxIdleClosed      int64         `json:"max_idle_closed"`
+	MaxIdleTimeClosed  int64         `json:"max_idle_time_closed"`
+	MaxLifetimeClosed  int64         `json:"max_lifetime_closed"`
+}
+
+func newPoolStats(s sql.DBStats) PoolStats {
+	return PoolStats{
+		MaxOpenConnections: s.MaxOpenConnections,
+		OpenConnections:    s.OpenConnections,
+		InUse:              s.InUse,
+		Idle:               s.Idle,
+		WaitCount:          s.WaitCount,
+		WaitDuration:       s.WaitDuration,
+		MaxIdleClosed:      s.MaxIdleClosed,
+		MaxIdleTimeClosed:  s.MaxIdleTimeClosed,
+		MaxLifetimeClosed:  s.MaxLifetimeClosed,
+	}
+}
+
+type ConnectionHealth struct {
+	Name    string        `json:"name"`
+	Status  HealthStatus  `json:"status"`
+	Latency time.Duration `json:"latency"`
+	Error   string        `json:"error,omitempty"`
+	Stats   PoolStats     `json:"stats"`
+}
+
+type HealthReport struct {
+	Status      HealthStatus       `json:"status"`
+	CheckedAt   time.Time          `json:"checked_at"`
+	Connections []ConnectionHealth `json:"connections"`
+}
+
+// NewHealthReport summarizes connections: up when all are up, down when none is.
+func NewHealthReport(connections ...ConnectionHealth) HealthReport {
+	up := 0
+	for _, c := range connections {
+		if c.Status == StatusUp {
+			up++
+		}
+	}
+	status := StatusDegraded
+	switch {
+	case up == len(connections) && up > 0:
+		status = StatusUp
+	case up == 0:
+		status = StatusDown
+	}
+	return HealthReport{
+		Status:      status,
+		CheckedAt:   time.Now(),
+		Connections: connections,
+	}
+}
+
+// CheckDB pings db and collects its pool statistics.
+func CheckDB(ctx context.Context, name string, db *gorm.DB) ConnectionHealth {
+	h := ConnectionHealth{Name: name, Status: StatusDown}
+	if db == nil {
+		h.Error = "not connected"
+		return h
+	}
+	sqlDb, err := db.DB()
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	start := time.Now()
+	err = sqlDb.PingContext(ctx)
+	h.Latency = time.Since(start)
+	h.Stats = newPoolStats(sqlDb.Stats())
+	if err != nil {
+		h.Error = err.Error()
+		return h
+	}
+	h.Status = StatusUp
+	return h
+}
+
+func (ds *DataSource) Check(ctx context.Context) HealthReport {
+	return NewHealthReport(CheckDB(ctx, "primary", ds.db))
+}
@@ -0,0 +1,51 @@
+package datasources
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+)
+
+// SavepointPool makes the transactions begun on pool run as savepoints, so that transactions.Run can nest in a
+// transaction or a session that already holds one, e.g. a *sql.Tx or a *sql.Conn.
+func SavepointPool(pool gorm.ConnPool) gorm.ConnPool {
+	return &savepointPool{ConnPool: pool, seq: new(int64)}
+}
+
+// SessionDB returns a session of db that runs every statement on pool.
+func SessionDB(ctx context.Context, db *gorm.DB, pool gorm.ConnPool) *gorm.DB {
+	session := db.Session(&gorm.Session{NewDB: true, Context: ctx})
+	session.Statement.ConnPool = pool
+	return session
+}
+
+type savepointPool struct {
+	gorm.ConnPool
+	seq *int64
+}
+
+func (p *savepointPool) BeginTx(ctx context.Context, _ *sql.TxOptions) (gorm.ConnPool, error) {
+	name := fmt.Sprintf("gormsource_%d", atomic.AddInt64(p.seq, 1))
+	if _, err := p.ExecContext(ctx, "SAVEPOINT "+name); err != nil {
+		return nil, err
+	}
+	return &savepoint{savepointPool: p, name: name}, nil
+}
+
+type savepoint struct {
+	*savepointPool
+	name string
+}
+
+func (s *savepoint) Commit() error {
+	_, err := s.ExecContext(context.Background(), "RELEASE SAVEPOINT "+s.name)
+	return err
+}
+
+func (s *savepoint) Rollback() error {
+	_, err := s.ExecContext(context.Background(), "ROLLBACK TO SAVEPOINT "+s.name)
+	return err
+}
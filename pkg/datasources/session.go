@@ -0,0 +1,112 @@
+package datasources
+
+import (
+	"context"
+	"database/sql/driver"
+
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/pkg/errors"
+)
+
+// Conn is a newly opened physical connection passed to Config.OnConnect.
+type Conn struct {
+	conn driver.Conn
+}
+
+// Raw returns the driver connection.
+func (c *Conn) Raw() driver.Conn {
+	return c.conn
+}
+
+func (c *Conn) ExecContext(ctx context.Context, query string, args ...interface{}) error {
+	values := make([]driver.NamedValue, 0, len(args))
+	for i, arg := range args {
+		v, err := driver.DefaultParameterConverter.ConvertValue(arg)
+		if err != nil {
+			return err
+		}
+		values = append(values, driver.NamedValue{Ordinal: i + 1, Value: v})
+	}
+	if execer, ok := c.conn.(driver.ExecerContext); ok {
+		_, err := execer.ExecContext(ctx, query, values)
+		if !errors.Is(err, driver.ErrSkip) {
+			return err
+		}
+	}
+	var stmt driver.Stmt
+	var err error
+	if preparer, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = preparer.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = stmt.Close()
+	}()
+	if execer, ok := stmt.(driver.StmtExecContext); ok {
+		_, err = execer.ExecContext(ctx, values)
+		return err
+	}
+	plain := make([]driver.Value, 0, len(values))
+	for _, v := range values {
+		plain = append(plain, v.Value)
+	}
+	_, err = stmt.Exec(plain)
+	return err
+}
+
+// sessionConnector runs the init statements and Config.OnConnect on every new connection.
+type sessionConnector struct {
+	driver.Connector
+	statements []string
+	onConnect  func(ctx context.Context, conn *Conn) error
+}
+
+func (c *sessionConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = c.init(ctx, &Conn{conn: conn}); err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (c *sessionConnector) init(ctx context.Context, conn *Conn) error {
+	for _, stmt := range c.statements {
+		if err := conn.ExecContext(ctx, stmt); err != nil {
+			return errors.Wrap(err, stmt)
+		}
+	}
+	if c.onConnect != nil {
+		return c.onConnect(ctx, conn)
+	}
+	return nil
+}
+
+// dsnConnector opens connections of a DriverBuilder with a fixed DSN.
+type dsnConnector struct {
+	driver driver.Driver
+	dsn    string
+}
+
+func newConnector(builder dialects.DriverBuilder, dsn string) (driver.Connector, error) {
+	d := builder.Driver()
+	if dc, ok := d.(driver.DriverContext); ok {
+		return dc.OpenConnector(dsn)
+	}
+	return &dsnConnector{driver: d, dsn: dsn}, nil
+}
+
+func (c *dsnConnector) Connect(_ context.Context) (driver.Conn, error) {
+	return c.driver.Open(c.dsn)
+}
+
+func (c *dsnConnector) Driver() driver.Driver {
+	return c.driver
+}
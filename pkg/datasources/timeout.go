@@ -0,0 +1,94 @@
+package datasources
+
+import (
+	"context"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const timeoutsPlugin = "gormsource:timeouts"
+
+type contextKey struct {
+	key string
+}
+
+func (key contextKey) String() string {
+	return key.key
+}
+
+var timeoutsKey = contextKey{key: "timeouts"}
+
+// Timeouts overrides Config.StatementTimeout and Config.LockTimeout for a single transaction.
+type Timeouts struct {
+	Statement time.Duration
+	Lock      time.Duration
+}
+
+// WithTimeouts applies t to the transactions that transactions.Run and replicas.Run start with ctx.
+func WithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey, t)
+}
+
+// timeouts is registered as a gorm plugin so that transactions can find the defaults of their datasource.
+type timeouts struct {
+	builder   dialects.TimeoutBuilder
+	statement time.Duration
+	lock      time.Duration
+}
+
+func (t *timeouts) Name() string {
+	return timeoutsPlugin
+}
+
+func (t *timeouts) Initialize(_ *gorm.DB) error {
+	return nil
+}
+
+func (c *Config) timeoutStatements() ([]string, error) {
+	if c.StatementTimeout <= 0 && c.LockTimeout <= 0 {
+		return nil, nil
+	}
+	builder, ok := c.dialect.(dialects.TimeoutBuilder)
+	if !ok {
+		return nil, errors.Errorf("%s does not support timeouts", c.dialect.Name())
+	}
+	return builder.TimeoutStatements(c.StatementTimeout, c.LockTimeout), nil
+}
+
+func (c *Config) useTimeouts(db *gorm.DB) error {
+	builder, ok := c.dialect.(dialects.TimeoutBuilder)
+	if !ok {
+		return nil
+	}
+	return db.Use(&timeouts{builder: builder, statement: c.StatementTimeout, lock: c.LockTimeout})
+}
+
+// ApplyTimeouts sets the Timeouts of ctx on tx. restore must be called before tx is committed or rolled back.
+func ApplyTimeouts(ctx context.Context, tx *gorm.DB) (restore func(), err error) {
+	restore = func() {}
+	t, ok := ctx.Value(timeoutsKey).(Timeouts)
+	if !ok || (t.Statement <= 0 && t.Lock <= 0) {
+		return restore, nil
+	}
+	plugin, ok := tx.Config.Plugins[timeoutsPlugin].(*timeouts)
+	if !ok {
+		return restore, errors.Errorf("%s does not support timeouts", tx.Dialector.Name())
+	}
+	set, reset := plugin.builder.LocalTimeoutStatements(t.Statement, t.Lock, plugin.statement, plugin.lock)
+	for _, stmt := range set {
+		if err = tx.Exec(stmt).Error; err != nil {
+			return restore, err
+		}
+	}
+	return func() {
+		for _, stmt := range reset {
+			if err := tx.Exec(stmt).Error; err != nil {
+				logging.Warn("%v", err)
+			}
+		}
+	}, nil
+}
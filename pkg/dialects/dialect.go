@@ -1,19 +1,22 @@
 package dialects
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
 	"strings"
 	"time"
 
-	"github.com/goccha/envar/pkg/log"
-	"github.com/pkg/errors"
+	"github.com/goccha/gormsource/pkg/logging"
 	"gorm.io/gorm"
 )
 
 type Builder interface {
 	Name() string
 	Build(user, password, host string, port int, dbname string) gorm.Dialector
+	BuildE(ctx context.Context, user, password, host string, port int, dbname string) (gorm.Dialector, error)
 	BuildString(user, password, host string, port int, dbname string) string
+	Redacted(user, password, host string, port int, dbname string) string
 	BuildDialector(url string) gorm.Dialector
 }
 
@@ -32,25 +35,81 @@ type Errors interface {
 	IsNotAvailableLock(err error) bool
 }
 
+type PermanentErrors interface {
+	IsPermanent(err error) bool
+}
+
+// AuthErrors is implemented by builders that can recognize rejected credentials.
+type AuthErrors interface {
+	IsAuthFailure(err error) bool
+}
+
+// DriverBuilder is implemented by builders that can open connections through a driver.Connector.
+type DriverBuilder interface {
+	Driver() driver.Driver
+	BuildConn(dsn string, conn *sql.DB) gorm.Dialector
+}
+
+// TimeoutBuilder is implemented by builders that can limit statement execution and lock wait times.
+type TimeoutBuilder interface {
+	// TimeoutStatements sets the timeouts of a session. A zero duration leaves that timeout unchanged.
+	TimeoutStatements(statement, lock time.Duration) []string
+	// LocalTimeoutStatements sets the timeouts for the current transaction only.
+	// restore runs before the transaction ends and puts back the session defaults, zero meaning the server default.
+	LocalTimeoutStatements(statement, lock, defaultStatement, defaultLock time.Duration) (set, restore []string)
+}
+
+// MigrationBuilder is implemented by builders that can serialize schema migrations across processes.
+type MigrationBuilder interface {
+	// LockStatements take and release a lock named name for the session. When transaction is true, lock begins a
+	// transaction that holds the lock, and the migrations run in its savepoints.
+	LockStatements(name string) (lock, unlock string, transaction bool)
+	// TransactionalDDL reports whether DDL statements are rolled back with their transaction.
+	TransactionalDDL() bool
+}
+
+// LockQuery is implemented by migration builders whose lock statement reports a failure in its result instead of
+// an error, e.g. GET_LOCK of MySQL.
+type LockQuery interface {
+	// LockQuery reports whether lock is a query that returns 1 when the lock is acquired.
+	LockQuery() bool
+}
+
 type Extension func(dialect, dsn string) (*sql.DB, error)
 
-func Connect(dialect, dsn string, f Extension) (db *sql.DB, err error) {
-	d := 1 * time.Second
-	timeout := true
-	count := 20
-	for count > 0 {
-		if db, err = f(dialect, dsn); err != nil {
-			log.Info("%v", err)
-			time.Sleep(d)
-		} else {
-			timeout = false
-			log.Info("connection established.")
-			break
-		}
-		count--
+func Connect(dialect, dsn string, f Extension) (*sql.DB, error) {
+	return ConnectContext(context.Background(), dialect, dsn, f, nil)
+}
+
+type singleAttemptKey struct{}
+
+// SingleAttempt returns a context in which ConnectContext tries only once, for callers that already retry.
+func SingleAttempt(ctx context.Context) context.Context {
+	return context.WithValue(ctx, singleAttemptKey{}, true)
+}
+
+func ConnectContext(ctx context.Context, dialect, dsn string, f Extension, policy *RetryPolicy) (db *sql.DB, err error) {
+	if policy == nil {
+		policy = DefaultRetryPolicy()
+	}
+	if ctx.Value(singleAttemptKey{}) != nil {
+		policy = &RetryPolicy{MaxAttempts: 1, Permanent: policy.Permanent}
+	}
+	err = policy.Do(ctx, func(ctx context.Context) (err error) {
+		db, err = f(dialect, dsn)
+		return
+	})
+	if err != nil {
+		return nil, err
 	}
-	if timeout {
-		return nil, errors.New("ping timeout")
+	logging.Info("connection established.")
+	return db, nil
+}
+
+// EnvKey joins prefix and name with an underscore, e.g. EnvKey("BILLING", "MYSQL_CHARSET").
+func EnvKey(prefix, name string) string {
+	if prefix == "" {
+		return name
 	}
-	return
+	return prefix + "_" + name
 }
@@ -0,0 +1,58 @@
+package dialects
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const RedactedPassword = "xxxxx"
+
+var (
+	redactQuery    = regexp.MustCompile(`(?i)([?&](?:password|pass|passwd|pwd|_auth_pass|sslpassword)=)[^&]*`)
+	redactKeyValue = regexp.MustCompile(`(?i)(\b(?:password|sslpassword)\s*=\s*)('(?:[^'\\]|\\.)*'|\S+)`)
+)
+
+// Mask replaces a non-empty password with RedactedPassword.
+func Mask(password string) string {
+	if password == "" {
+		return ""
+	}
+	return RedactedPassword
+}
+
+// RedactDSN masks passwords in URL, mysql and key/value style DSNs.
+func RedactDSN(dsn string) string {
+	if strings.Contains(dsn, "://") {
+		if u, err := url.Parse(dsn); err == nil {
+			if u.User != nil {
+				if _, ok := u.User.Password(); ok {
+					u.User = url.UserPassword(u.User.Username(), RedactedPassword)
+				}
+			}
+			dsn = u.String()
+			dsn = redactQuery.ReplaceAllString(dsn, "${1}"+RedactedPassword)
+			return dsn
+		}
+	}
+	dsn = redactQuery.ReplaceAllString(dsn, "${1}"+RedactedPassword)
+	dsn = redactKeyValue.ReplaceAllString(dsn, "${1}"+RedactedPassword)
+	return redactUserInfo(dsn)
+}
+
+// redactUserInfo masks the password of a mysql style "user:password@protocol(address)/dbname" DSN.
+func redactUserInfo(dsn string) string {
+	slash := strings.LastIndex(dsn, "/")
+	if slash < 0 {
+		return dsn
+	}
+	at := strings.LastIndex(dsn[:slash], "@")
+	if at < 0 {
+		return dsn
+	}
+	colon := strings.Index(dsn[:at], ":")
+	if colon < 0 || colon+1 == at {
+		return dsn
+	}
+	return dsn[:colon+1] + RedactedPassword + dsn[at:]
+}
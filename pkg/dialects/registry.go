@@ -0,0 +1,86 @@
+package dialects
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownDialect = errors.New("unknown dialect")
+
+// Factory creates a Builder from dialect specific parameters, e.g. {"charset": "utf8mb4"} for mysql.
+type Factory func(params map[string]string) (Builder, error)
+
+// EnvFactory creates the Option that applies the environment variables of a dialect, e.g. BILLING_MYSQL_CHARSET for "BILLING".
+type EnvFactory func(prefix string) Option
+
+var registry = struct {
+	mu           sync.RWMutex
+	factories    map[string]Factory
+	environments map[string]EnvFactory
+}{factories: make(map[string]Factory), environments: make(map[string]EnvFactory)}
+
+// Register makes a dialect module available by name. Dialect modules call it from init.
+func Register(f Factory, names ...string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, name := range names {
+		registry.factories[strings.ToLower(name)] = f
+	}
+}
+
+// RegisterEnv makes the environment variables of a dialect module available by name. Dialect modules call it from init.
+func RegisterEnv(f EnvFactory, names ...string) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	for _, name := range names {
+		registry.environments[strings.ToLower(name)] = f
+	}
+}
+
+// EnvOption returns the Option that applies the environment variables of the named dialect with prefix,
+// or nil when the dialect has not registered them.
+func EnvOption(name, prefix string) Option {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	if f, ok := registry.environments[strings.ToLower(name)]; ok {
+		return f(prefix)
+	}
+	return nil
+}
+
+func Lookup(name string) (Factory, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	f, ok := registry.factories[strings.ToLower(name)]
+	return f, ok
+}
+
+func Registered() []string {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+	names := make([]string, 0, len(registry.factories))
+	for k := range registry.factories {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New creates a Builder of the named dialect. The dialect module must be imported.
+func New(name string, params map[string]string, options ...Option) (Builder, error) {
+	f, ok := Lookup(name)
+	if !ok {
+		return nil, errors.Wrapf(ErrUnknownDialect, "%s (registered: %s)", name, strings.Join(Registered(), ", "))
+	}
+	b, err := f(params)
+	if err != nil {
+		return nil, errors.Wrap(err, name)
+	}
+	for _, opt := range options {
+		opt(b)
+	}
+	return b, nil
+}
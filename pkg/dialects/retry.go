@@ -0,0 +1,135 @@
+package dialects
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/pkg/errors"
+)
+
+var ErrRetryExhausted = errors.New("retry exhausted")
+
+// RetryPolicy controls how connection attempts are repeated at startup.
+type RetryPolicy struct {
+	MaxAttempts     int
+	MaxElapsedTime  time.Duration
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+	Multiplier      float64
+	Jitter          float64
+	Permanent       func(err error) bool
+}
+
+func DefaultRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts:     20,
+		InitialInterval: 1 * time.Second,
+		MaxInterval:     1 * time.Second,
+		Multiplier:      1,
+	}
+}
+
+// WithPermanent returns a copy of the policy that additionally treats errors matched by f as permanent.
+func (p *RetryPolicy) WithPermanent(f func(err error) bool) *RetryPolicy {
+	if p == nil {
+		p = DefaultRetryPolicy()
+	}
+	c := *p
+	if f == nil {
+		return &c
+	}
+	if prev := p.Permanent; prev != nil {
+		c.Permanent = func(err error) bool {
+			return prev(err) || f(err)
+		}
+	} else {
+		c.Permanent = f
+	}
+	return &c
+}
+
+func (p *RetryPolicy) isPermanent(err error) bool {
+	return p.Permanent != nil && p.Permanent(err)
+}
+
+func (p *RetryPolicy) interval(attempt int) time.Duration {
+	d := float64(p.InitialInterval)
+	if p.Multiplier > 0 {
+		d *= math.Pow(p.Multiplier, float64(attempt-1))
+	}
+	if p.MaxInterval > 0 && d > float64(p.MaxInterval) {
+		d = float64(p.MaxInterval)
+	}
+	if p.Jitter > 0 {
+		delta := p.Jitter * d
+		d = d - delta + rand.Float64()*2*delta
+	}
+	return time.Duration(d)
+}
+
+// Do calls f until it succeeds, returns a permanent error, or the policy or ctx gives up.
+// Failures are reported as *ConnectError.
+func (p *RetryPolicy) Do(ctx context.Context, f func(ctx context.Context) error) error {
+	if p == nil {
+		p = DefaultRetryPolicy()
+	}
+	start := time.Now()
+	attempt := 0
+	for {
+		attempt++
+		err := f(ctx)
+		if err == nil {
+			return nil
+		}
+		logging.Info("attempt %d: %v", attempt, err)
+		if p.isPermanent(err) {
+			return &ConnectError{Attempts: attempt, Elapsed: time.Since(start), Err: err}
+		}
+		if p.MaxAttempts > 0 && attempt >= p.MaxAttempts {
+			return &ConnectError{Attempts: attempt, Elapsed: time.Since(start), Err: err, Reason: ErrRetryExhausted}
+		}
+		wait := p.interval(attempt)
+		if p.MaxElapsedTime > 0 && time.Since(start)+wait > p.MaxElapsedTime {
+			return &ConnectError{Attempts: attempt, Elapsed: time.Since(start), Err: err, Reason: ErrRetryExhausted}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return &ConnectError{Attempts: attempt, Elapsed: time.Since(start), Err: err, Reason: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+}
+
+// ConnectError wraps the last error seen while connecting.
+// Reason tells why retrying stopped and is nil when the error was permanent.
+type ConnectError struct {
+	Attempts int
+	Elapsed  time.Duration
+	Err      error
+	Reason   error
+}
+
+func (e *ConnectError) Error() string {
+	if e.Reason != nil {
+		return fmt.Sprintf("connect failed after %d attempt(s) (%v): %v", e.Attempts, e.Reason, e.Err)
+	}
+	return fmt.Sprintf("connect failed after %d attempt(s): %v", e.Attempts, e.Err)
+}
+
+func (e *ConnectError) Unwrap() error {
+	return e.Err
+}
+
+func (e *ConnectError) Cause() error {
+	return e.Err
+}
+
+func (e *ConnectError) Is(target error) bool {
+	return e.Reason != nil && errors.Is(e.Reason, target)
+}
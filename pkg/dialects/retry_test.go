@@ -0,0 +1,101 @@
+package dialects
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var errRefused = errors.New("connection refused")
+var errDenied = errors.New("access denied")
+
+func TestRetryPolicyDo(t *testing.T) {
+	tests := []struct {
+		name     string
+		policy   *RetryPolicy
+		failures int
+		err      error
+		attempts int
+		reason   error
+	}{
+		{name: "success", policy: &RetryPolicy{MaxAttempts: 3}, failures: 2, err: errRefused, attempts: 3},
+		{name: "exhausted", policy: &RetryPolicy{MaxAttempts: 3}, failures: 5, err: errRefused, attempts: 3, reason: ErrRetryExhausted},
+		{name: "permanent", policy: (&RetryPolicy{MaxAttempts: 3}).WithPermanent(func(err error) bool {
+			return errors.Is(err, errDenied)
+		}), failures: 5, err: errDenied, attempts: 1},
+		{name: "max elapsed time", policy: &RetryPolicy{InitialInterval: 30 * time.Millisecond, MaxElapsedTime: 45 * time.Millisecond},
+			failures: 100, err: errRefused, attempts: 2, reason: ErrRetryExhausted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := tt.policy.Do(context.Background(), func(ctx context.Context) error {
+				attempts++
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if attempts != tt.attempts {
+				t.Errorf("expected=%d, actual=%d", tt.attempts, attempts)
+			}
+			if tt.failures < tt.attempts {
+				if err != nil {
+					t.Errorf("expected=nil, actual=%v", err)
+				}
+				return
+			}
+			var ce *ConnectError
+			if !errors.As(err, &ce) {
+				t.Fatalf("expected=*ConnectError, actual=%T", err)
+			}
+			if ce.Attempts != tt.attempts || ce.Reason != tt.reason {
+				t.Errorf("expected=%d/%v, actual=%d/%v", tt.attempts, tt.reason, ce.Attempts, ce.Reason)
+			}
+			if !errors.Is(err, tt.err) || errors.Cause(err) != tt.err {
+				t.Errorf("expected=%v, actual=%v", tt.err, err)
+			}
+			if tt.reason != nil && !errors.Is(err, tt.reason) {
+				t.Errorf("expected=%v, actual=%v", tt.reason, err)
+			}
+		})
+	}
+}
+
+func TestRetryPolicyDoCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	policy := &RetryPolicy{InitialInterval: time.Hour}
+	attempts := 0
+	err := policy.Do(ctx, func(ctx context.Context) error {
+		attempts++
+		cancel()
+		return errRefused
+	})
+	if attempts != 1 {
+		t.Errorf("expected=1, actual=%d", attempts)
+	}
+	if !errors.Is(err, context.Canceled) || !errors.Is(err, errRefused) {
+		t.Errorf("expected=%v, actual=%v", context.Canceled, err)
+	}
+	if errors.Is(err, ErrRetryExhausted) {
+		t.Errorf("expected a canceled error not to be exhausted: %v", err)
+	}
+}
+
+func TestConnectContextSingleAttempt(t *testing.T) {
+	attempts := 0
+	_, err := ConnectContext(SingleAttempt(context.Background()), "test", "", func(dialect, dsn string) (*sql.DB, error) {
+		attempts++
+		return nil, errRefused
+	}, &RetryPolicy{MaxAttempts: 5})
+	if attempts != 1 {
+		t.Errorf("expected=1, actual=%d", attempts)
+	}
+	var ce *ConnectError
+	if !errors.As(err, &ce) || ce.Err != errRefused {
+		t.Errorf("expected=%v, actual=%v", errRefused, err)
+	}
+}
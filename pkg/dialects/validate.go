@@ -0,0 +1,114 @@
+package dialects
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/goccha/envar"
+)
+
+// Validator is implemented by Builders that can check their own options.
+type Validator interface {
+	Validate() error
+}
+
+// MultiError collects every problem found in a configuration.
+type MultiError []error
+
+// Add appends err, flattening nested MultiErrors. nil is ignored.
+func (e *MultiError) Add(err error) {
+	if err == nil {
+		return
+	}
+	var m MultiError
+	if errors.As(err, &m) {
+		*e = append(*e, m...)
+		return
+	}
+	*e = append(*e, err)
+}
+
+func (e *MultiError) Addf(format string, args ...interface{}) {
+	e.Add(fmt.Errorf(format, args...))
+}
+
+// Err returns nil when no problem was added.
+func (e MultiError) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+func (e MultiError) Error() string {
+	if len(e) == 1 {
+		return e[0].Error()
+	}
+	msgs := make([]string, 0, len(e))
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("%d problems: %s", len(e), strings.Join(msgs, "; "))
+}
+
+func (e MultiError) Is(target error) bool {
+	for _, err := range e {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e MultiError) As(target interface{}) bool {
+	for _, err := range e {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *MultiError) check(names []string, kind string, parse func(string) error) {
+	for _, name := range names {
+		if name == "" || !envar.Has(name) {
+			continue
+		}
+		if v := envar.String(name); parse(v) != nil {
+			e.Addf("%s: invalid %s %q", name, kind, v)
+		}
+		return
+	}
+}
+
+// CheckInt reports the first set variable of names unless it is an integer.
+func (e *MultiError) CheckInt(names ...string) {
+	e.check(names, "integer", func(v string) error {
+		_, err := strconv.Atoi(v)
+		return err
+	})
+}
+
+func (e *MultiError) CheckUint(names ...string) {
+	e.check(names, "unsigned integer", func(v string) error {
+		_, err := strconv.ParseUint(v, 10, 0)
+		return err
+	})
+}
+
+func (e *MultiError) CheckBool(names ...string) {
+	e.check(names, "boolean", func(v string) error {
+		_, err := strconv.ParseBool(v)
+		return err
+	})
+}
+
+func (e *MultiError) CheckDuration(names ...string) {
+	e.check(names, "duration", func(v string) error {
+		_, err := time.ParseDuration(v)
+		return err
+	})
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"reflect"
+	"sync"
+	"time"
 
 	"github.com/pkg/errors"
 	"gorm.io/gorm"
@@ -74,15 +76,17 @@ func (c *TransactionContainer) addCommit(h ...Hook) {
 	}
 	c.commit = append(c.commit, h...)
 }
-func (c *TransactionContainer) invokeRollback(ctx context.Context) {
+func (c *TransactionContainer) invokeRollback(ctx context.Context) int {
 	if c.rollback != nil {
 		c.rollback.Invoke(ctx)
 	}
+	return len(c.rollback)
 }
-func (c *TransactionContainer) invokeCommit(ctx context.Context) {
+func (c *TransactionContainer) invokeCommit(ctx context.Context) int {
 	if c.commit != nil {
 		c.commit.Invoke(ctx)
 	}
+	return len(c.commit)
 }
 
 var withTransaction = contextKey{key: "transactionContext"}
@@ -93,6 +97,132 @@ func WithTransaction() interface{} {
 
 type Begin func(ctx context.Context, opts ...*sql.TxOptions) *gorm.DB
 
+type Outcome string
+
+const (
+	OutcomeCommit   Outcome = "commit"
+	OutcomeRollback Outcome = "rollback"
+	OutcomePanic    Outcome = "panic"
+	OutcomeError    Outcome = "error"
+)
+
+// TransactionObserver is notified when RunTransaction finishes. OutcomeError means that begin or commit failed.
+type TransactionObserver func(ctx context.Context, transactionType string, outcome Outcome, elapsed time.Duration)
+
+// callbacks is a copy-on-write list, so that a snapshot can be iterated without holding the lock.
+type callbacks[T any] struct {
+	mu sync.RWMutex
+	fs []*T
+}
+
+func (c *callbacks[T]) add(f T) (remove func()) {
+	p := &f
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.fs = append(c.fs[:len(c.fs):len(c.fs)], p)
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		fs := make([]*T, 0, len(c.fs))
+		for _, v := range c.fs {
+			if v != p {
+				fs = append(fs, v)
+			}
+		}
+		c.fs = fs
+	}
+}
+
+func (c *callbacks[T]) list() []*T {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.fs
+}
+
+var observers callbacks[TransactionObserver]
+
+// Observe registers o until the returned remove is called.
+func Observe(o TransactionObserver) (remove func()) {
+	return observers.add(o)
+}
+
+func notify(ctx context.Context, key any, outcome Outcome, start time.Time) {
+	fs := observers.list()
+	if len(fs) == 0 {
+		return
+	}
+	elapsed := time.Since(start)
+	txType := transactionType(ctx, key)
+	for _, f := range fs {
+		(*f)(ctx, txType, outcome, elapsed)
+	}
+}
+
+// TransactionInfo describes a transaction that is about to begin. Options is nil when the defaults of the datasource apply.
+type TransactionInfo struct {
+	Type    string
+	Options *sql.TxOptions
+}
+
+// TransactionEnd is called with the outcome of a transaction and the number of commit or rollback hooks that ran.
+type TransactionEnd func(ctx context.Context, outcome Outcome, hooks int, err error)
+
+// TransactionStarter is called before RunTransaction begins a transaction. The returned context is used to
+// begin the transaction, so that the statements inherit its values.
+type TransactionStarter func(ctx context.Context, info TransactionInfo) (context.Context, TransactionEnd)
+
+var starters callbacks[TransactionStarter]
+
+// OnStart registers s until the returned remove is called.
+func OnStart(s TransactionStarter) (remove func()) {
+	return starters.add(s)
+}
+
+func startTransaction(ctx context.Context, txType string, opts []*sql.TxOptions) (context.Context, []TransactionEnd) {
+	fs := starters.list()
+	if len(fs) == 0 {
+		return ctx, nil
+	}
+	info := TransactionInfo{Type: txType}
+	if len(opts) > 0 {
+		info.Options = opts[0]
+	}
+	ends := make([]TransactionEnd, 0, len(fs))
+	for _, f := range fs {
+		var end TransactionEnd
+		if ctx, end = (*f)(ctx, info); end != nil {
+			ends = append(ends, end)
+		}
+	}
+	return ctx, ends
+}
+
+func finish(ctx context.Context, ends []TransactionEnd, outcome Outcome, hooks int, err error) {
+	for i := len(ends) - 1; i >= 0; i-- {
+		ends[i](ctx, outcome, hooks, err)
+	}
+}
+
+func transactionType(ctx context.Context, key any) string {
+	if c, ok := fromContext(ctx, key); ok && c != nil && c.TransactionType != "" {
+		return c.TransactionType
+	}
+	if key == withTransaction {
+		return Transaction
+	}
+	return ReadOnly
+}
+
+var transactionTypeKey = contextKey{key: "transactionType"}
+
+// TransactionType returns the type of the transaction ctx runs in, or "" outside of a transaction.
+func TransactionType(ctx context.Context) string {
+	if v, ok := ctx.Value(transactionTypeKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
 func IsActive(v interface{}) bool {
 	if container, ok := v.(*TransactionContainer); ok {
 		if committer, ok := container.DB.Statement.ConnPool.(gorm.TxCommitter); ok &&
@@ -104,9 +234,20 @@ func IsActive(v interface{}) bool {
 }
 
 func RunTransaction[T any](ctx context.Context, begin Begin, txFunc func(ctx context.Context, db *gorm.DB) (context.Context, T, error), key any, opts ...*sql.TxOptions) (res T, err error) {
+	if !transactionGate.enter(ctx) {
+		err = ErrShuttingDown
+		return
+	}
+	defer transactionGate.leave()
+	ctx = context.WithValue(ctx, inFlight, true)
+	ctx = context.WithValue(ctx, transactionTypeKey, transactionType(ctx, key))
+	ctx, ends := startTransaction(ctx, TransactionType(ctx), opts)
+	start := time.Now()
 	db := begin(ctx, opts...)
 	if db.Error != nil {
 		err = db.Error
+		notify(ctx, key, OutcomeError, start)
+		finish(ctx, ends, OutcomeError, 0, err)
 		return
 	}
 	defer func() {
@@ -119,19 +260,31 @@ func RunTransaction[T any](ctx context.Context, begin Begin, txFunc func(ctx con
 				err = errors.New("panic")
 			}
 		}
+		hooks := 0
 		if err != nil {
 			db.Rollback()
 			if f, ok := fromContext(ctx, key); ok {
-				f.invokeRollback(ctx)
+				hooks = f.invokeRollback(ctx)
+			}
+			if p != nil {
+				notify(ctx, key, OutcomePanic, start)
+				finish(ctx, ends, OutcomePanic, hooks, err)
+			} else {
+				notify(ctx, key, OutcomeRollback, start)
+				finish(ctx, ends, OutcomeRollback, hooks, err)
 			}
 		} else {
 			if db = db.Commit(); db.Error != nil {
 				err = db.Error
+				notify(ctx, key, OutcomeError, start)
+				finish(ctx, ends, OutcomeError, hooks, err)
 				return
 			}
 			if f, ok := fromContext(ctx, key); ok {
-				f.invokeCommit(ctx)
+				hooks = f.invokeCommit(ctx)
 			}
+			notify(ctx, key, OutcomeCommit, start)
+			finish(ctx, ends, OutcomeCommit, hooks, nil)
 		}
 		if p != nil {
 			panic(p) // re-throw panic after Rollback
@@ -0,0 +1,43 @@
+package foundations
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestObserve(t *testing.T) {
+	calls := make([]string, 0)
+	first := Observe(func(ctx context.Context, txType string, outcome Outcome, elapsed time.Duration) {
+		calls = append(calls, "first")
+	})
+	second := Observe(func(ctx context.Context, txType string, outcome Outcome, elapsed time.Duration) {
+		calls = append(calls, "second")
+	})
+	snapshot := observers.list()
+	first()
+	notify(context.Background(), nil, OutcomeCommit, time.Now())
+	second()
+	second()
+	notify(context.Background(), nil, OutcomeCommit, time.Now())
+	if len(calls) != 1 || calls[0] != "second" {
+		t.Errorf("expected=[second], actual=%v", calls)
+	}
+	if len(snapshot) != 2 || len(observers.list()) != 0 {
+		t.Errorf("expected=2/0, actual=%d/%d", len(snapshot), len(observers.list()))
+	}
+}
+
+func TestOnStart(t *testing.T) {
+	starts := 0
+	remove := OnStart(func(ctx context.Context, info TransactionInfo) (context.Context, TransactionEnd) {
+		starts++
+		return ctx, nil
+	})
+	startTransaction(context.Background(), Transaction, nil)
+	remove()
+	startTransaction(context.Background(), Transaction, nil)
+	if starts != 1 {
+		t.Errorf("expected=1, actual=%d", starts)
+	}
+}
@@ -0,0 +1,80 @@
+package foundations
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+var ErrShuttingDown = errors.New("shutting down")
+
+var inFlight = contextKey{key: "inFlightTransaction"}
+
+type gate struct {
+	mu      sync.Mutex
+	closing bool
+	active  int
+	idle    chan struct{}
+}
+
+var transactionGate = &gate{}
+
+// enter reports whether a new transaction may start.
+// Transactions nested in one that is already running are let through so that it can finish.
+func (g *gate) enter(ctx context.Context) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.closing && ctx.Value(inFlight) == nil {
+		return false
+	}
+	g.active++
+	return true
+}
+
+func (g *gate) leave() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.active--
+	if g.closing && g.active == 0 && g.idle != nil {
+		close(g.idle)
+		g.idle = nil
+	}
+}
+
+// Shutdown rejects new transactions with ErrShuttingDown and waits until the running ones finish or ctx is done.
+func Shutdown(ctx context.Context) error {
+	g := transactionGate
+	g.mu.Lock()
+	g.closing = true
+	if g.active == 0 {
+		g.mu.Unlock()
+		return nil
+	}
+	if g.idle == nil {
+		g.idle = make(chan struct{})
+	}
+	idle := g.idle
+	g.mu.Unlock()
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return errors.Wrap(ctx.Err(), "transactions still running")
+	}
+}
+
+// Resume accepts new transactions again after Shutdown.
+func Resume() {
+	g := transactionGate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.closing = false
+}
+
+func ActiveTransactions() int {
+	g := transactionGate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.active
+}
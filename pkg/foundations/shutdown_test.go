@@ -0,0 +1,141 @@
+package foundations
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+var errNotSupported = errors.New("not supported")
+
+// pool is a connection pool whose transactions always commit.
+type pool struct{}
+
+func (p *pool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errNotSupported
+}
+func (p *pool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errNotSupported
+}
+func (p *pool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errNotSupported
+}
+func (p *pool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+func (p *pool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return p, nil
+}
+func (p *pool) Commit() error {
+	return nil
+}
+func (p *pool) Rollback() error {
+	return nil
+}
+
+func begin(t *testing.T) Begin {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{ConnPool: &pool{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return func(ctx context.Context, opts ...*sql.TxOptions) *gorm.DB {
+		return db.WithContext(ctx).Begin(opts...)
+	}
+}
+
+func run(ctx context.Context, b Begin, f func(ctx context.Context) error) error {
+	_, err := RunTransaction(ctx, b, func(ctx context.Context, db *gorm.DB) (context.Context, struct{}, error) {
+		return ctx, struct{}{}, f(ctx)
+	}, WithTransaction())
+	return err
+}
+
+// waitClosed waits until the gate rejects new transactions and reports whether it did before the timeout.
+func waitClosed(b Begin) bool {
+	for i := 0; i < 100; i++ {
+		if errors.Is(run(context.Background(), b, func(ctx context.Context) error { return nil }), ErrShuttingDown) {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestShutdown(t *testing.T) {
+	t.Cleanup(Resume)
+	b := begin(t)
+	started, proceed := make(chan struct{}), make(chan struct{})
+	nested := make(chan error, 1)
+	go func() {
+		_ = run(context.Background(), b, func(ctx context.Context) error {
+			close(started)
+			<-proceed
+			err := run(ctx, b, func(ctx context.Context) error { return nil })
+			nested <- err
+			return err
+		})
+	}()
+	<-started
+	if expected, actual := 1, ActiveTransactions(); expected != actual {
+		t.Errorf("expected=%d, actual=%d", expected, actual)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- Shutdown(context.Background())
+	}()
+	if !waitClosed(b) {
+		t.Fatalf("expected=%v, actual=new transactions accepted", ErrShuttingDown)
+	}
+	select {
+	case err := <-done:
+		t.Fatalf("expected Shutdown to wait for the running transaction, actual=%v", err)
+	default:
+	}
+	close(proceed)
+	if err := <-nested; err != nil {
+		t.Errorf("expected the nested transaction to complete, actual=%v", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected=nil, actual=%v", err)
+	}
+	if expected, actual := 0, ActiveTransactions(); expected != actual {
+		t.Errorf("expected=%d, actual=%d", expected, actual)
+	}
+}
+
+func TestShutdownDeadline(t *testing.T) {
+	t.Cleanup(Resume)
+	b := begin(t)
+	started, proceed, finished := make(chan struct{}), make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(finished)
+		_ = run(context.Background(), b, func(ctx context.Context) error {
+			close(started)
+			<-proceed
+			return nil
+		})
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := Shutdown(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected=%v, actual=%v", context.DeadlineExceeded, err)
+	}
+	close(proceed)
+	<-finished
+	if err := run(context.Background(), b, func(ctx context.Context) error { return nil }); !errors.Is(err, ErrShuttingDown) {
+		t.Errorf("expected=%v, actual=%v", ErrShuttingDown, err)
+	}
+	Resume()
+	if err := run(context.Background(), b, func(ctx context.Context) error { return nil }); err != nil {
+		t.Errorf("expected the gate to be reopened by Resume, actual=%v", err)
+	}
+	if err := Shutdown(context.Background()); err != nil {
+		t.Errorf("expected=nil without running transactions, actual=%v", err)
+	}
+}
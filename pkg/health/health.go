@@ -0,0 +1,153 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/registry"
+)
+
+// Checker is implemented by *datasources.DataSource and *replicas.DB.
+type Checker interface {
+	Check(ctx context.Context) datasources.HealthReport
+}
+
+type CheckerFunc func(ctx context.Context) datasources.HealthReport
+
+func (f CheckerFunc) Check(ctx context.Context) datasources.HealthReport {
+	return f(ctx)
+}
+
+type Response struct {
+	Status datasources.HealthStatus            `json:"status"`
+	Checks map[string]datasources.HealthReport `json:"checks,omitempty"`
+}
+
+// Handler serves liveness on paths ending in /live or /livez and readiness on any other path.
+type Handler struct {
+	Timeout  time.Duration
+	Registry bool
+	mu       sync.RWMutex
+	checkers map[string]Checker
+}
+
+func NewHandler() *Handler {
+	return &Handler{
+		Timeout:  5 * time.Second,
+		checkers: make(map[string]Checker),
+	}
+}
+
+func (h *Handler) Add(name string, c Checker) *Handler {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.checkers[name] = c
+	return h
+}
+
+// WithRegistry also checks every datasource in the registry as "<name>" and "<name>.replicas".
+func (h *Handler) WithRegistry() *Handler {
+	h.Registry = true
+	return h
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	if strings.HasSuffix(path, "/live") || strings.HasSuffix(path, "/livez") {
+		h.Liveness(w, r)
+		return
+	}
+	h.Readiness(w, r)
+}
+
+func (h *Handler) Liveness(w http.ResponseWriter, _ *http.Request) {
+	write(w, http.StatusOK, &Response{Status: datasources.StatusUp})
+}
+
+// Readiness answers 503 when any check is down. Degraded checks are still ready.
+func (h *Handler) Readiness(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, h.Timeout)
+		defer cancel()
+	}
+	res := h.Check(ctx)
+	code := http.StatusOK
+	if res.Status == datasources.StatusDown {
+		code = http.StatusServiceUnavailable
+	}
+	write(w, code, res)
+}
+
+func (h *Handler) Check(ctx context.Context) *Response {
+	checkers := h.collect()
+	res := &Response{
+		Status: datasources.StatusUp,
+		Checks: make(map[string]datasources.HealthReport, len(checkers)),
+	}
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for name, c := range checkers {
+		wg.Add(1)
+		go func(name string, c Checker) {
+			defer wg.Done()
+			report := c.Check(ctx)
+			mu.Lock()
+			defer mu.Unlock()
+			res.Checks[name] = report
+			switch report.Status {
+			case datasources.StatusDown:
+				res.Status = datasources.StatusDown
+			case datasources.StatusDegraded:
+				if res.Status == datasources.StatusUp {
+					res.Status = datasources.StatusDegraded
+				}
+			}
+		}(name, c)
+	}
+	wg.Wait()
+	return res
+}
+
+func (h *Handler) collect() map[string]Checker {
+	h.mu.RLock()
+	checkers := make(map[string]Checker, len(h.checkers))
+	for k, v := range h.checkers {
+		checkers[k] = v
+	}
+	h.mu.RUnlock()
+	if h.Registry {
+		for _, name := range registry.Names() {
+			src, err := registry.Get(name)
+			if err != nil {
+				continue
+			}
+			if src.Primary != nil {
+				db, n := src.Primary, name
+				checkers[name] = CheckerFunc(func(ctx context.Context) datasources.HealthReport {
+					return datasources.NewHealthReport(datasources.CheckDB(ctx, n, db))
+				})
+			}
+			if c, ok := src.Replica.(Checker); ok {
+				checkers[name+".replicas"] = c
+			}
+		}
+	}
+	return checkers
+}
+
+func write(w http.ResponseWriter, code int, res *Response) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		logging.Warn("%v", err)
+	}
+}
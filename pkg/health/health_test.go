@@ -0,0 +1,106 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/registry"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+func status(s datasources.HealthStatus) Checker {
+	return CheckerFunc(func(ctx context.Context) datasources.HealthReport {
+		return datasources.HealthReport{Status: s}
+	})
+}
+
+func serve(h http.Handler, path string) (int, *Response) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	res := &Response{}
+	_ = json.Unmarshal(rec.Body.Bytes(), res)
+	return rec.Code, res
+}
+
+func TestHandler(t *testing.T) {
+	tests := []struct {
+		path   string
+		checks []datasources.HealthStatus
+		code   int
+		status datasources.HealthStatus
+	}{
+		{path: "/ready", checks: []datasources.HealthStatus{datasources.StatusUp, datasources.StatusUp}, code: http.StatusOK, status: datasources.StatusUp},
+		{path: "/ready", checks: []datasources.HealthStatus{datasources.StatusUp, datasources.StatusDegraded}, code: http.StatusOK, status: datasources.StatusDegraded},
+		{path: "/readyz", checks: []datasources.HealthStatus{datasources.StatusDegraded, datasources.StatusDown}, code: http.StatusServiceUnavailable, status: datasources.StatusDown},
+		{path: "/live", checks: []datasources.HealthStatus{datasources.StatusDown}, code: http.StatusOK, status: datasources.StatusUp},
+		{path: "/health/livez/", checks: []datasources.HealthStatus{datasources.StatusDown}, code: http.StatusOK, status: datasources.StatusUp},
+		{path: "/health/alive", checks: []datasources.HealthStatus{datasources.StatusDown}, code: http.StatusServiceUnavailable, status: datasources.StatusDown},
+	}
+	for _, tt := range tests {
+		h := NewHandler()
+		for i, s := range tt.checks {
+			h.Add(string(rune('a'+i)), status(s))
+		}
+		code, res := serve(h, tt.path)
+		if tt.code != code || tt.status != res.Status {
+			t.Errorf("%s %v: expected=%d/%s, actual=%d/%s", tt.path, tt.checks, tt.code, tt.status, code, res.Status)
+		}
+		if live := strings.Contains(tt.path, "/live"); live && len(res.Checks) != 0 {
+			t.Errorf("%s: expected liveness not to run checks, actual=%v", tt.path, res.Checks)
+		}
+	}
+}
+
+type replica struct {
+	s datasources.HealthStatus
+}
+
+func (r *replica) DB() *gorm.DB {
+	return nil
+}
+
+func (r *replica) Close() {}
+
+func (r *replica) Check(ctx context.Context) datasources.HealthReport {
+	return datasources.HealthReport{Status: r.s}
+}
+
+func TestWithRegistry(t *testing.T) {
+	t.Cleanup(registry.CloseAll)
+	primary, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.Register("billing", primary, &replica{s: datasources.StatusDegraded}); err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.Register("audit", nil, &replica{s: datasources.StatusUp}); err != nil {
+		t.Fatal(err)
+	}
+	res := NewHandler().Add("cache", status(datasources.StatusUp)).WithRegistry().Check(context.Background())
+	names := make([]string, 0, len(res.Checks))
+	for k := range res.Checks {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	if expected, actual := "audit.replicas billing billing.replicas cache", strings.Join(names, " "); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	// the primary of billing has no connection pool, so its ping fails.
+	if expected, actual := datasources.StatusDown, res.Checks["billing"].Status; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if expected, actual := datasources.StatusDegraded, res.Checks["billing.replicas"].Status; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if expected, actual := datasources.StatusDown, res.Status; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
@@ -0,0 +1,233 @@
+package logging
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+type contextKey struct {
+	key string
+}
+
+func (key contextKey) String() string {
+	return key.key
+}
+
+var (
+	requestIdKey  = contextKey{key: "requestId"}
+	datasourceKey = contextKey{key: "datasource"}
+)
+
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIdKey, id)
+}
+
+func RequestID(ctx context.Context) string {
+	if v, ok := ctx.Value(requestIdKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// WithDatasource records the registry name of the datasource used with ctx. transactions.Use and replicas.Use set it.
+func WithDatasource(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, datasourceKey, name)
+}
+
+func Datasource(ctx context.Context) string {
+	if v, ok := ctx.Value(datasourceKey).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// GormLogger is a logger.Interface that writes to slog with the request ID, transaction type and datasource name of the context.
+type GormLogger struct {
+	logger.Config
+	// Attrs adds application specific attributes of the context.
+	Attrs  func(ctx context.Context) []slog.Attr
+	logger *slog.Logger
+}
+
+func NewGormLogger(l *slog.Logger, config logger.Config) *GormLogger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &GormLogger{Config: config, logger: l}
+}
+
+func (l *GormLogger) LogMode(level logger.LogLevel) logger.Interface {
+	v := *l
+	v.LogLevel = level
+	return &v
+}
+
+func (l *GormLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	if l.LogLevel >= logger.Info {
+		l.log(ctx, slog.LevelInfo, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *GormLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	if l.LogLevel >= logger.Warn {
+		l.log(ctx, slog.LevelWarn, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *GormLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	if l.LogLevel >= logger.Error {
+		l.log(ctx, slog.LevelError, fmt.Sprintf(msg, data...))
+	}
+}
+
+func (l *GormLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if l.LogLevel <= logger.Silent {
+		return
+	}
+	elapsed := time.Since(begin)
+	switch {
+	case err != nil && l.LogLevel >= logger.Error && (!errors.Is(err, gorm.ErrRecordNotFound) || !l.IgnoreRecordNotFoundError):
+		sql, rows := fc()
+		l.log(ctx, slog.LevelError, "query failed", append(queryAttrs(sql, rows, elapsed), slog.String("error", err.Error()))...)
+	case l.SlowThreshold > 0 && elapsed > l.SlowThreshold && l.LogLevel >= logger.Warn:
+		sql, rows := fc()
+		l.log(ctx, slog.LevelWarn, "slow query", append(queryAttrs(sql, rows, elapsed), slog.Duration("threshold", l.SlowThreshold))...)
+	case l.LogLevel == logger.Info:
+		sql, rows := fc()
+		l.log(ctx, slog.LevelInfo, "query", queryAttrs(sql, rows, elapsed)...)
+	}
+}
+
+// ParamsFilter omits the parameters from the logged SQL when ParameterizedQueries is set.
+func (l *GormLogger) ParamsFilter(_ context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.ParameterizedQueries {
+		return sql, nil
+	}
+	return sql, params
+}
+
+func queryAttrs(sql string, rows int64, elapsed time.Duration) []slog.Attr {
+	return []slog.Attr{
+		slog.String("sql", sql),
+		slog.Int64("rows", rows),
+		slog.Duration("elapsed", elapsed),
+	}
+}
+
+func (l *GormLogger) log(ctx context.Context, level slog.Level, msg string, attrs ...slog.Attr) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	attrs = append(attrs, slog.String("caller", caller()))
+	if v := RequestID(ctx); v != "" {
+		attrs = append(attrs, slog.String("request_id", v))
+	}
+	if v := foundations.TransactionType(ctx); v != "" {
+		attrs = append(attrs, slog.String("tx_type", v))
+	}
+	if v := Datasource(ctx); v != "" {
+		attrs = append(attrs, slog.String("datasource", v))
+	}
+	if l.Attrs != nil {
+		attrs = append(attrs, l.Attrs(ctx)...)
+	}
+	l.logger.LogAttrs(ctx, level, msg, attrs...)
+}
+
+var packageDir = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Dir(file) + "/"
+}()
+
+// caller returns the first frame outside of gorm and this package.
+func caller() string {
+	pcs := [16]uintptr{}
+	n := runtime.Callers(3, pcs[:])
+	frames := runtime.CallersFrames(pcs[:n])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.File, packageDir) && !strings.Contains(frame.File, "gorm.io/") {
+			return frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return ""
+		}
+	}
+}
+
+type gormHolder struct {
+	logger.Interface
+}
+
+// LevelLogger lets the log level of a *gorm.DB change while the DB is in use.
+type LevelLogger struct {
+	base    logger.Interface
+	current atomic.Value
+}
+
+func NewLevelLogger(base logger.Interface) *LevelLogger {
+	l := &LevelLogger{base: base}
+	l.current.Store(gormHolder{base})
+	return l
+}
+
+// Level returns the LevelLogger of db, installing one when db has none yet.
+// Install it before db is shared between goroutines.
+func Level(db *gorm.DB) *LevelLogger {
+	if l, ok := db.Logger.(*LevelLogger); ok {
+		return l
+	}
+	l := NewLevelLogger(db.Logger)
+	db.Logger = l
+	return l
+}
+
+func (l *LevelLogger) SetLevel(level logger.LogLevel) {
+	l.current.Store(gormHolder{l.base.LogMode(level)})
+}
+
+func (l *LevelLogger) get() logger.Interface {
+	return l.current.Load().(gormHolder).Interface
+}
+
+func (l *LevelLogger) LogMode(level logger.LogLevel) logger.Interface {
+	return l.get().LogMode(level)
+}
+
+func (l *LevelLogger) Info(ctx context.Context, msg string, data ...interface{}) {
+	l.get().Info(ctx, msg, data...)
+}
+
+func (l *LevelLogger) Warn(ctx context.Context, msg string, data ...interface{}) {
+	l.get().Warn(ctx, msg, data...)
+}
+
+func (l *LevelLogger) Error(ctx context.Context, msg string, data ...interface{}) {
+	l.get().Error(ctx, msg, data...)
+}
+
+func (l *LevelLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	l.get().Trace(ctx, begin, fc, err)
+}
+
+func (l *LevelLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if f, ok := l.get().(gorm.ParamsFilter); ok {
+		return f.ParamsFilter(ctx, sql, params...)
+	}
+	return sql, params
+}
@@ -0,0 +1,178 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+	"gorm.io/gorm/utils/tests"
+)
+
+var errNotSupported = errors.New("not supported")
+
+// pool is a connection pool that accepts every statement.
+type pool struct{}
+
+func (p *pool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errNotSupported
+}
+func (p *pool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return driver.RowsAffected(1), nil
+}
+func (p *pool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errNotSupported
+}
+func (p *pool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+func (p *pool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return p, nil
+}
+func (p *pool) Commit() error {
+	return nil
+}
+func (p *pool) Rollback() error {
+	return nil
+}
+
+// records returns the JSON records written to buf.
+func records(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var list []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		if line == "" {
+			continue
+		}
+		r := map[string]any{}
+		if err := json.Unmarshal([]byte(line), &r); err != nil {
+			t.Fatal(err)
+		}
+		list = append(list, r)
+	}
+	return list
+}
+
+func open(t *testing.T, l logger.Interface) *gorm.DB {
+	// the tests live next to the logger, so only its own files may be skipped when the caller is looked up.
+	dir := packageDir
+	packageDir = filepath.Join(dir, "gorm.go")
+	t.Cleanup(func() { packageDir = dir })
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{ConnPool: &pool{}, Logger: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+func update(db *gorm.DB) error {
+	return db.Exec("UPDATE users SET name = ?", "secret").Error
+}
+
+func TestGormLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	db := open(t, NewGormLogger(slog.New(slog.NewJSONHandler(buf, nil)), logger.Config{LogLevel: logger.Info}))
+	ctx := WithDatasource(WithRequestID(context.Background(), "req-1"), "billing")
+	begin := func(ctx context.Context, opts ...*sql.TxOptions) *gorm.DB {
+		return db.WithContext(ctx).Begin(opts...)
+	}
+	_, err := foundations.RunTransaction(ctx, begin, func(ctx context.Context, tx *gorm.DB) (context.Context, struct{}, error) {
+		return ctx, struct{}{}, update(tx)
+	}, foundations.WithTransaction())
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := records(t, buf)
+	if len(list) != 1 {
+		t.Fatalf("expected=1, actual=%d: %s", len(list), buf.String())
+	}
+	r := list[0]
+	for k, expected := range map[string]string{
+		"msg":        "query",
+		"sql":        `UPDATE users SET name = "secret"`,
+		"request_id": "req-1",
+		"tx_type":    foundations.Transaction,
+		"datasource": "billing",
+	} {
+		if actual, _ := r[k].(string); expected != actual {
+			t.Errorf("%s: expected=%s, actual=%s", k, expected, actual)
+		}
+	}
+	if actual, _ := r["caller"].(string); !strings.Contains(actual, "gorm_test.go:") {
+		t.Errorf("expected=gorm_test.go, actual=%s", actual)
+	}
+}
+
+func TestParameterizedQueries(t *testing.T) {
+	buf := &bytes.Buffer{}
+	db := open(t, NewGormLogger(slog.New(slog.NewJSONHandler(buf, nil)), logger.Config{LogLevel: logger.Info, ParameterizedQueries: true}))
+	if err := update(db); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "secret") {
+		t.Errorf("expected the parameters to be omitted, actual=%s", buf.String())
+	}
+	if list := records(t, buf); len(list) != 1 || list[0]["sql"] != "UPDATE users SET name = ?" {
+		t.Errorf("expected=UPDATE users SET name = ?, actual=%v", list)
+	}
+}
+
+func TestSlowThreshold(t *testing.T) {
+	buf := &bytes.Buffer{}
+	l := NewGormLogger(slog.New(slog.NewJSONHandler(buf, nil)), logger.Config{LogLevel: logger.Warn, SlowThreshold: 100 * time.Millisecond})
+	fc := func() (string, int64) { return "SELECT 1", 1 }
+	l.Trace(context.Background(), time.Now(), fc, nil)
+	if buf.Len() != 0 {
+		t.Errorf("expected no record below the threshold, actual=%s", buf.String())
+	}
+	l.Trace(context.Background(), time.Now().Add(-time.Second), fc, nil)
+	list := records(t, buf)
+	if len(list) != 1 {
+		t.Fatalf("expected=1, actual=%d: %s", len(list), buf.String())
+	}
+	if expected, actual := "slow query", list[0]["msg"]; expected != actual {
+		t.Errorf("expected=%s, actual=%v", expected, actual)
+	}
+	if expected, actual := float64(100*time.Millisecond), list[0]["threshold"]; expected != actual {
+		t.Errorf("expected=%v, actual=%v", expected, actual)
+	}
+}
+
+func TestLevelLogger(t *testing.T) {
+	buf := &bytes.Buffer{}
+	db := open(t, NewGormLogger(slog.New(slog.NewJSONHandler(buf, nil)), logger.Config{LogLevel: logger.Warn}))
+	l := Level(db)
+	if Level(db) != l {
+		t.Errorf("expected Level to return the installed LevelLogger")
+	}
+	if err := update(db); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no query record at warn, actual=%s", buf.String())
+	}
+	l.SetLevel(logger.Info)
+	if err := update(db); err != nil {
+		t.Fatal(err)
+	}
+	if list := records(t, buf); len(list) != 1 || list[0]["msg"] != "query" {
+		t.Errorf("expected=query, actual=%v", list)
+	}
+	buf.Reset()
+	l.SetLevel(logger.Silent)
+	if err := update(db); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("expected no record when silent, actual=%s", buf.String())
+	}
+}
@@ -0,0 +1,92 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync/atomic"
+
+	"github.com/goccha/envar/pkg/log"
+)
+
+// Logger receives gormsource's own messages such as connect and close.
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+type envarLogger struct{}
+
+func (envarLogger) Debug(format string, args ...interface{}) { log.Debug(format, args...) }
+func (envarLogger) Info(format string, args ...interface{})  { log.Info(format, args...) }
+func (envarLogger) Warn(format string, args ...interface{})  { log.Warn(format, args...) }
+func (envarLogger) Error(format string, args ...interface{}) { log.Error(format, args...) }
+
+type holder struct {
+	Logger
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(holder{envarLogger{}})
+}
+
+// SetLogger replaces the package logger. nil restores the default github.com/goccha/envar/pkg/log.
+func SetLogger(l Logger) {
+	if l == nil {
+		l = envarLogger{}
+	}
+	current.Store(holder{l})
+}
+
+// SetSlog sends gormsource's own messages to l.
+func SetSlog(l *slog.Logger) {
+	SetLogger(Slog(l))
+}
+
+func get() Logger {
+	return current.Load().(holder).Logger
+}
+
+func Debug(format string, args ...interface{}) { get().Debug(format, args...) }
+func Info(format string, args ...interface{})  { get().Info(format, args...) }
+func Warn(format string, args ...interface{})  { get().Warn(format, args...) }
+func Error(format string, args ...interface{}) { get().Error(format, args...) }
+
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// Slog adapts l to Logger.
+func Slog(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return slogLogger{l: l}
+}
+
+func (s slogLogger) log(level slog.Level, format string, args ...interface{}) {
+	ctx := context.Background()
+	if s.l.Enabled(ctx, level) {
+		s.l.Log(ctx, level, fmt.Sprintf(format, args...))
+	}
+}
+
+func (s slogLogger) Debug(format string, args ...interface{}) {
+	s.log(slog.LevelDebug, format, args...)
+}
+
+func (s slogLogger) Info(format string, args ...interface{}) {
+	s.log(slog.LevelInfo, format, args...)
+}
+
+func (s slogLogger) Warn(format string, args ...interface{}) {
+	s.log(slog.LevelWarn, format, args...)
+}
+
+func (s slogLogger) Error(format string, args ...interface{}) {
+	s.log(slog.LevelError, format, args...)
+}
@@ -0,0 +1,55 @@
+package logging
+
+import (
+	"bytes"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+type recorder struct {
+	lines []string
+}
+
+func (r *recorder) Debug(format string, args ...interface{}) { r.add("debug", format, args...) }
+func (r *recorder) Info(format string, args ...interface{})  { r.add("info", format, args...) }
+func (r *recorder) Warn(format string, args ...interface{})  { r.add("warn", format, args...) }
+func (r *recorder) Error(format string, args ...interface{}) { r.add("error", format, args...) }
+
+func (r *recorder) add(level, format string, args ...interface{}) {
+	r.lines = append(r.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func TestSetLogger(t *testing.T) {
+	t.Cleanup(func() { SetLogger(nil) })
+	r := &recorder{}
+	SetLogger(r)
+	Debug("open %s", "billing")
+	Info("connected %s", "billing")
+	Warn("retry %d", 1)
+	Error("closed")
+	if expected, actual := "debug open billing|info connected billing|warn retry 1|error closed", strings.Join(r.lines, "|"); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	SetLogger(nil)
+	Info("not recorded")
+	if len(r.lines) != 4 {
+		t.Errorf("expected SetLogger(nil) to restore the default logger, actual=%v", r.lines)
+	}
+}
+
+func TestSetSlog(t *testing.T) {
+	t.Cleanup(func() { SetLogger(nil) })
+	buf := &bytes.Buffer{}
+	SetSlog(slog.New(slog.NewTextHandler(buf, &slog.HandlerOptions{Level: slog.LevelInfo})))
+	Debug("open %s", "billing")
+	Warn("retry %d", 1)
+	actual := buf.String()
+	if strings.Contains(actual, "open billing") {
+		t.Errorf("expected debug to be filtered by the handler level, actual=%s", actual)
+	}
+	if !strings.Contains(actual, `level=WARN msg="retry 1"`) {
+		t.Errorf(`expected=level=WARN msg="retry 1", actual=%s`, actual)
+	}
+}
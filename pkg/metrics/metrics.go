@@ -0,0 +1,337 @@
+package metrics
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/registry"
+	"gorm.io/gorm"
+)
+
+const namespace = "gormsource"
+
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Statser is implemented by *datasources.DataSource.
+type Statser interface {
+	Stats() sql.DBStats
+}
+
+// MultiStatser is implemented by *replicas.DB.
+type MultiStatser interface {
+	Stats() []sql.DBStats
+}
+
+type member struct {
+	datasource string
+	role       string
+	index      int
+	stats      sql.DBStats
+}
+
+// Collector exposes pool statistics and transaction outcomes in the Prometheus text format.
+type Collector struct {
+	Registry     bool
+	mu           sync.RWMutex
+	primaries    map[string]Statser
+	replicas     map[string]MultiStatser
+	transactions *transactionMetrics
+	remove       func()
+}
+
+// NewCollector creates a Collector and starts observing foundations.RunTransaction until Close is called.
+func NewCollector() *Collector {
+	c := &Collector{
+		primaries:    make(map[string]Statser),
+		replicas:     make(map[string]MultiStatser),
+		transactions: newTransactionMetrics(DefaultBuckets),
+	}
+	c.remove = foundations.Observe(c.transactions.observe)
+	return c
+}
+
+// Close stops observing transactions.
+func (c *Collector) Close() {
+	c.remove()
+}
+
+func (c *Collector) AddPrimary(name string, s Statser) *Collector {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.primaries[name] = s
+	return c
+}
+
+func (c *Collector) AddReplicas(name string, s MultiStatser) *Collector {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.replicas[name] = s
+	return c
+}
+
+// WithRegistry also collects every datasource in the registry.
+func (c *Collector) WithRegistry() *Collector {
+	c.Registry = true
+	return c
+}
+
+type dbStats struct {
+	db *gorm.DB
+}
+
+func (s dbStats) Stats() sql.DBStats {
+	if s.db != nil {
+		if sqlDb, err := s.db.DB(); err == nil {
+			return sqlDb.Stats()
+		}
+	}
+	return sql.DBStats{}
+}
+
+func (c *Collector) members() []member {
+	c.mu.RLock()
+	primaries := make(map[string]Statser, len(c.primaries))
+	for k, v := range c.primaries {
+		primaries[k] = v
+	}
+	replicas := make(map[string]MultiStatser, len(c.replicas))
+	for k, v := range c.replicas {
+		replicas[k] = v
+	}
+	c.mu.RUnlock()
+	if c.Registry {
+		for _, name := range registry.Names() {
+			src, err := registry.Get(name)
+			if err != nil {
+				continue
+			}
+			if _, ok := primaries[name]; !ok && src.Primary != nil {
+				primaries[name] = dbStats{src.Primary}
+			}
+			if v, ok := src.Replica.(MultiStatser); ok {
+				if _, ok = replicas[name]; !ok {
+					replicas[name] = v
+				}
+			}
+		}
+	}
+	members := make([]member, 0, len(primaries)+len(replicas))
+	for name, s := range primaries {
+		members = append(members, member{datasource: name, role: "primary", stats: s.Stats()})
+	}
+	for name, s := range replicas {
+		for i, stats := range s.Stats() {
+			members = append(members, member{datasource: name, role: "replica", index: i, stats: stats})
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		if a.datasource != b.datasource {
+			return a.datasource < b.datasource
+		}
+		if a.role != b.role {
+			return a.role < b.role
+		}
+		return a.index < b.index
+	})
+	return members
+}
+
+type poolMetric struct {
+	name  string
+	help  string
+	kind  string
+	value func(s sql.DBStats) float64
+}
+
+var poolMetrics = []poolMetric{
+	{"db_max_open_connections", "Maximum number of open connections to the database.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) }},
+	{"db_open_connections", "The number of established connections both in use and idle.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) }},
+	{"db_in_use_connections", "The number of connections currently in use.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.InUse) }},
+	{"db_idle_connections", "The number of idle connections.", "gauge",
+		func(s sql.DBStats) float64 { return float64(s.Idle) }},
+	{"db_wait_count_total", "The total number of connections waited for.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) }},
+	{"db_wait_duration_seconds_total", "The total time blocked waiting for a new connection.", "counter",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() }},
+	{"db_max_idle_closed_total", "The total number of connections closed due to SetMaxIdleConns.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) }},
+	{"db_max_idle_time_closed_total", "The total number of connections closed due to SetConnMaxIdleTime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) }},
+	{"db_max_lifetime_closed_total", "The total number of connections closed due to SetConnMaxLifetime.", "counter",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) }},
+}
+
+// WriteTo writes all metrics in the Prometheus text exposition format.
+func (c *Collector) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+	members := c.members()
+	for _, g := range poolMetrics {
+		writeHeader(cw, g.name, g.help, g.kind)
+		for _, m := range members {
+			labels := [][2]string{{"datasource", m.datasource}, {"role", m.role}}
+			if m.role == "replica" {
+				labels = append(labels, [2]string{"member", strconv.Itoa(m.index)})
+			}
+			writeSample(cw, g.name, labels, g.value(m.stats))
+		}
+	}
+	c.transactions.writeTo(cw)
+	if err := bw.Flush(); err != nil && cw.err == nil {
+		cw.err = err
+	}
+	return cw.n, cw.err
+}
+
+func (c *Collector) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if _, err := c.WriteTo(w); err != nil {
+		logging.Warn("%v", err)
+	}
+}
+
+type transactionKey struct {
+	txType  string
+	outcome foundations.Outcome
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+type transactionMetrics struct {
+	mu        sync.Mutex
+	buckets   []float64
+	counts    map[transactionKey]uint64
+	durations map[string]*histogram
+}
+
+func newTransactionMetrics(buckets []float64) *transactionMetrics {
+	return &transactionMetrics{
+		buckets:   buckets,
+		counts:    make(map[transactionKey]uint64),
+		durations: make(map[string]*histogram),
+	}
+}
+
+func (t *transactionMetrics) observe(_ context.Context, txType string, outcome foundations.Outcome, elapsed time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.counts[transactionKey{txType: txType, outcome: outcome}]++
+	h, ok := t.durations[txType]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(t.buckets))}
+		t.durations[txType] = h
+	}
+	sec := elapsed.Seconds()
+	for i, b := range t.buckets {
+		if sec <= b {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += sec
+}
+
+func (t *transactionMetrics) writeTo(w io.Writer) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	keys := make([]transactionKey, 0, len(t.counts))
+	for k := range t.counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].txType != keys[j].txType {
+			return keys[i].txType < keys[j].txType
+		}
+		return keys[i].outcome < keys[j].outcome
+	})
+	name := "transactions_total"
+	writeHeader(w, name, "The total number of transactions by type and outcome.", "counter")
+	for _, k := range keys {
+		writeSample(w, name, [][2]string{{"type", k.txType}, {"outcome", string(k.outcome)}}, float64(t.counts[k]))
+	}
+	types := make([]string, 0, len(t.durations))
+	for k := range t.durations {
+		types = append(types, k)
+	}
+	sort.Strings(types)
+	name = "transaction_duration_seconds"
+	writeHeader(w, name, "Transaction duration by type.", "histogram")
+	for _, txType := range types {
+		h := t.durations[txType]
+		for i, b := range t.buckets {
+			writeSample(w, name+"_bucket", [][2]string{{"type", txType}, {"le", formatFloat(b)}}, float64(h.counts[i]))
+		}
+		writeSample(w, name+"_bucket", [][2]string{{"type", txType}, {"le", "+Inf"}}, float64(h.count))
+		writeSample(w, name+"_sum", [][2]string{{"type", txType}}, h.sum)
+		writeSample(w, name+"_count", [][2]string{{"type", txType}}, float64(h.count))
+	}
+}
+
+func writeHeader(w io.Writer, name, help, kind string) {
+	_, _ = fmt.Fprintf(w, "# HELP %s_%s %s\n# TYPE %s_%s %s\n", namespace, name, help, namespace, name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func writeSample(w io.Writer, name string, labels [][2]string, value float64) {
+	buf := &strings.Builder{}
+	buf.WriteString(namespace)
+	buf.WriteString("_")
+	buf.WriteString(name)
+	if len(labels) > 0 {
+		buf.WriteString("{")
+		for i, l := range labels {
+			if i > 0 {
+				buf.WriteString(",")
+			}
+			buf.WriteString(l[0])
+			buf.WriteString(`="`)
+			buf.WriteString(labelEscaper.Replace(l[1]))
+			buf.WriteString(`"`)
+		}
+		buf.WriteString("}")
+	}
+	buf.WriteString(" ")
+	buf.WriteString(formatFloat(value))
+	buf.WriteString("\n")
+	_, _ = io.WriteString(w, buf.String())
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
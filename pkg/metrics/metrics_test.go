@@ -0,0 +1,66 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+)
+
+type statser sql.DBStats
+
+func (s statser) Stats() sql.DBStats {
+	return sql.DBStats(s)
+}
+
+type multiStatser []sql.DBStats
+
+func (s multiStatser) Stats() []sql.DBStats {
+	return s
+}
+
+func TestWriteTo(t *testing.T) {
+	c := NewCollector()
+	defer c.Close()
+	c.transactions = newTransactionMetrics([]float64{.1, 1})
+	c.AddPrimary("billing", statser{MaxOpenConnections: 10, OpenConnections: 3, InUse: 1, Idle: 2, WaitDuration: 1500 * time.Millisecond})
+	c.AddReplicas("billing", multiStatser{{OpenConnections: 1}, {OpenConnections: 2}})
+	c.AddPrimary(`a"b`, statser{})
+	c.transactions.observe(context.Background(), "read_write", foundations.OutcomeCommit, 50*time.Millisecond)
+	c.transactions.observe(context.Background(), "read_write", foundations.OutcomeRollback, 500*time.Millisecond)
+	buf := &bytes.Buffer{}
+	n, err := c.WriteTo(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("expected=%d, actual=%d", buf.Len(), n)
+	}
+	actual := buf.String()
+	tests := []string{
+		"# HELP gormsource_db_open_connections The number of established connections both in use and idle.\n# TYPE gormsource_db_open_connections gauge\n",
+		`gormsource_db_open_connections{datasource="a\"b",role="primary"} 0` + "\n" +
+			`gormsource_db_open_connections{datasource="billing",role="primary"} 3` + "\n" +
+			`gormsource_db_open_connections{datasource="billing",role="replica",member="0"} 1` + "\n" +
+			`gormsource_db_open_connections{datasource="billing",role="replica",member="1"} 2` + "\n",
+		`gormsource_db_wait_duration_seconds_total{datasource="billing",role="primary"} 1.5` + "\n",
+		"# TYPE gormsource_transactions_total counter\n" +
+			`gormsource_transactions_total{type="read_write",outcome="commit"} 1` + "\n" +
+			`gormsource_transactions_total{type="read_write",outcome="rollback"} 1` + "\n",
+		"# TYPE gormsource_transaction_duration_seconds histogram\n" +
+			`gormsource_transaction_duration_seconds_bucket{type="read_write",le="0.1"} 1` + "\n" +
+			`gormsource_transaction_duration_seconds_bucket{type="read_write",le="1"} 2` + "\n" +
+			`gormsource_transaction_duration_seconds_bucket{type="read_write",le="+Inf"} 2` + "\n" +
+			`gormsource_transaction_duration_seconds_sum{type="read_write"} 0.55` + "\n" +
+			`gormsource_transaction_duration_seconds_count{type="read_write"} 2` + "\n",
+	}
+	for _, expected := range tests {
+		if !strings.Contains(actual, expected) {
+			t.Errorf("expected=%s, actual=%s", expected, actual)
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package migrations
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/transactions"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const DefaultTable = "schema_migrations"
+
+var (
+	ErrChecksum  = errors.New("checksum mismatch")
+	ErrNoDown    = errors.New("no down migration")
+	ErrNotLocked = errors.New("migration lock not acquired")
+)
+
+var filePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is a pair of files such as 0001_create_users.up.sql and 0001_create_users.down.sql.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+	// Checksum is the SHA-256 of Up.
+	Checksum string
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%d_%s", m.Version, m.Name)
+}
+
+// Load reads the migrations in the root of fsys, ordered by version. Other files are ignored.
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[int64]*Migration)
+	for _, e := range entries {
+		match := filePattern.FindStringSubmatch(e.Name())
+		if e.IsDir() || match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, errors.Wrap(err, e.Name())
+		}
+		data, err := fs.ReadFile(fsys, e.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := versions[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			versions[version] = m
+		} else if m.Name != match[2] {
+			return nil, errors.Errorf("duplicate version %d: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+			sum := sha256.Sum256(data)
+			m.Checksum = hex.EncodeToString(sum[:])
+		} else {
+			m.Down = string(data)
+		}
+	}
+	migrations := make([]Migration, 0, len(versions))
+	for _, m := range versions {
+		if m.Checksum == "" {
+			return nil, errors.Errorf("%s: no up migration", m)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+type record struct {
+	Version   int64     `gorm:"primaryKey;autoIncrement:false"`
+	Name      string    `gorm:"size:255;not null"`
+	Checksum  string    `gorm:"size:64;not null"`
+	AppliedAt time.Time `gorm:"not null"`
+}
+
+type Status struct {
+	Migration
+	Applied   bool
+	AppliedAt time.Time
+	// Modified means that the up file changed after it was applied.
+	Modified bool
+}
+
+// Migrator applies migrations and records them in Table. Concurrent runners are serialized when the builder
+// implements dialects.MigrationBuilder, and every migration runs in transactions.Run when its DDL is transactional.
+type Migrator struct {
+	Table      string
+	db         *gorm.DB
+	builder    dialects.Builder
+	migrations []Migration
+}
+
+func New(db *gorm.DB, builder dialects.Builder, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{
+		Table:      DefaultTable,
+		db:         db,
+		builder:    builder,
+		migrations: migrations,
+	}, nil
+}
+
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Up applies every pending migration and returns the applied ones.
+func (m *Migrator) Up(ctx context.Context) (applied []Migration, err error) {
+	err = m.withLock(ctx, func(ctx context.Context, db *gorm.DB) error {
+		records, err := m.records(db, true)
+		if err != nil {
+			return err
+		}
+		for _, mig := range m.migrations {
+			if r, ok := records[mig.Version]; ok {
+				if r.Checksum != mig.Checksum {
+					return errors.Wrap(ErrChecksum, mig.String())
+				}
+				continue
+			}
+			if err = m.apply(ctx, db, mig, true); err != nil {
+				return err
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts the last n applied migrations and returns the reverted ones.
+func (m *Migrator) Down(ctx context.Context, n int) (reverted []Migration, err error) {
+	err = m.withLock(ctx, func(ctx context.Context, db *gorm.DB) error {
+		records, err := m.records(db, true)
+		if err != nil {
+			return err
+		}
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < n; i-- {
+			mig := m.migrations[i]
+			if _, ok := records[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return errors.Wrap(ErrNoDown, mig.String())
+			}
+			if err = m.apply(ctx, db, mig, false); err != nil {
+				return err
+			}
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	records, err := m.records(m.db.WithContext(ctx), false)
+	if err != nil {
+		return nil, err
+	}
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, mig := range m.migrations {
+		s := Status{Migration: mig}
+		if r, ok := records[mig.Version]; ok {
+			s.Applied = true
+			s.AppliedAt = r.AppliedAt
+			s.Modified = r.Checksum != mig.Checksum
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+func (m *Migrator) records(db *gorm.DB, create bool) (map[int64]record, error) {
+	if !db.Migrator().HasTable(m.Table) {
+		if !create {
+			return nil, nil
+		}
+		if err := db.Table(m.Table).AutoMigrate(&record{}); err != nil {
+			return nil, errors.Wrap(err, m.Table)
+		}
+	}
+	var list []record
+	if err := db.Table(m.Table).Order("version").Find(&list).Error; err != nil {
+		return nil, errors.Wrap(err, m.Table)
+	}
+	records := make(map[int64]record, len(list))
+	for _, r := range list {
+		records[r.Version] = r
+	}
+	return records, nil
+}
+
+// withLock runs f on a dedicated connection that holds the migration lock.
+func (m *Migrator) withLock(ctx context.Context, f func(ctx context.Context, db *gorm.DB) error) (err error) {
+	sqlDb, err := m.db.DB()
+	if err != nil {
+		return err
+	}
+	conn, err := sqlDb.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = conn.Close()
+	}()
+	var pool gorm.ConnPool = conn
+	if b, ok := m.builder.(dialects.MigrationBuilder); ok {
+		lock, unlock, transaction := b.LockStatements(m.Table)
+		if err = m.lock(ctx, conn, lock); err != nil {
+			return errors.Wrap(err, "lock migrations")
+		}
+		defer func() {
+			if _, e := conn.ExecContext(context.Background(), unlock); e != nil && err == nil {
+				err = errors.Wrap(e, "unlock migrations")
+			}
+		}()
+		if transaction {
+			pool = datasources.SavepointPool(conn)
+		}
+	}
+	return f(ctx, datasources.SessionDB(ctx, m.db, pool))
+}
+
+func (m *Migrator) lock(ctx context.Context, conn *sql.Conn, lock string) error {
+	if q, ok := m.builder.(dialects.LockQuery); !ok || !q.LockQuery() {
+		_, err := conn.ExecContext(ctx, lock)
+		return err
+	}
+	var acquired sql.NullInt64
+	if err := conn.QueryRowContext(ctx, lock).Scan(&acquired); err != nil {
+		return err
+	}
+	if !acquired.Valid || acquired.Int64 != 1 {
+		return ErrNotLocked
+	}
+	return nil
+}
+
+func (m *Migrator) transactionalDDL() bool {
+	if b, ok := m.builder.(dialects.MigrationBuilder); ok {
+		return b.TransactionalDDL()
+	}
+	return false
+}
+
+func (m *Migrator) apply(ctx context.Context, db *gorm.DB, mig Migration, up bool) error {
+	direction, script := "up", mig.Up
+	if !up {
+		direction, script = "down", mig.Down
+	}
+	logging.Info("migrate %s %s", direction, mig)
+	run := func(ctx context.Context, db *gorm.DB) error {
+		// statements are executed on the pool directly, so that "?" and "@" are not taken as parameters.
+		for _, stmt := range Split(script) {
+			if _, err := db.Statement.ConnPool.ExecContext(ctx, stmt); err != nil {
+				return err
+			}
+		}
+		if up {
+			return db.Table(m.Table).Create(&record{
+				Version:   mig.Version,
+				Name:      mig.Name,
+				Checksum:  mig.Checksum,
+				AppliedAt: time.Now(),
+			}).Error
+		}
+		return db.Table(m.Table).Where("version = ?", mig.Version).Delete(&record{}).Error
+	}
+	var err error
+	if m.transactionalDDL() {
+		_, err = transactions.Run(transactions.Begin(ctx, db), func(ctx context.Context, tx *gorm.DB) (struct{}, error) {
+			return struct{}{}, run(ctx, tx)
+		})
+	} else {
+		err = run(ctx, db)
+	}
+	return errors.Wrapf(err, "%s %s", direction, mig)
+}
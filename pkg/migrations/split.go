@@ -0,0 +1,192 @@
+package migrations
+
+import (
+	"strings"
+)
+
+// Split separates script into statements at semicolons outside of quotes, comments, PostgreSQL dollar quotes and
+// the BEGIN ... END blocks of triggers, procedures and functions, such as the body of a SQLite trigger.
+// Line comments are dropped. Quotes are escaped by doubling them. Backslash escapes such as MySQL's 'it\'s' are not
+// recognized, so a quote in a string must be doubled. The DELIMITER command of the mysql client is not supported.
+func Split(script string) []string {
+	var stmts []string
+	buf := &strings.Builder{}
+	stmt := &statement{}
+	flush := func() {
+		if s := strings.TrimSpace(buf.String()); s != "" {
+			stmts = append(stmts, s)
+		}
+		buf.Reset()
+		stmt = &statement{}
+	}
+	for i := 0; i < len(script); i++ {
+		c := script[i]
+		switch {
+		case c == '\'' || c == '"' || c == '`':
+			end := closeQuote(script, i+1, c)
+			buf.WriteString(script[i:end])
+			i = end - 1
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end - 1
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := strings.Index(script[i+2:], "*/")
+			if end < 0 {
+				end = len(script)
+			} else {
+				end = i + 2 + end + 2
+			}
+			buf.WriteString(script[i:end])
+			i = end - 1
+		case c == '$':
+			tag := dollarTag(script[i:])
+			if tag == "" {
+				buf.WriteByte(c)
+				continue
+			}
+			end := strings.Index(script[i+len(tag):], tag)
+			if end < 0 {
+				end = len(script)
+			} else {
+				end = i + len(tag) + end + len(tag)
+			}
+			buf.WriteString(script[i:end])
+			i = end - 1
+		case isWordStart(script, i):
+			end := wordEnd(script, i)
+			buf.WriteString(script[i:end])
+			stmt.word(strings.ToUpper(script[i:end]), script[end:])
+			i = end - 1
+		case c == ';' && stmt.depth > 0:
+			buf.WriteByte(c)
+		case c == ';':
+			flush()
+		default:
+			buf.WriteByte(c)
+		}
+	}
+	flush()
+	return stmts
+}
+
+// closeQuote returns the index after the quote that closes the one before start.
+func closeQuote(s string, start int, quote byte) int {
+	for i := start; i < len(s); i++ {
+		if s[i] == quote {
+			if i+1 < len(s) && s[i+1] == quote {
+				i++
+				continue
+			}
+			return i + 1
+		}
+	}
+	return len(s)
+}
+
+// dollarTag returns the tag such as $$ or $body$ at the start of s.
+func dollarTag(s string) string {
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '$':
+			return s[:i+1]
+		case c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 1:
+		default:
+			return ""
+		}
+	}
+	return ""
+}
+
+// statement follows the words of the statement being split. In triggers, procedures and functions, and in the
+// IF, WHILE and BEGIN blocks of T-SQL, BEGIN and CASE open a block that the next END closes.
+type statement struct {
+	words   int
+	routine bool
+	blocks  bool
+	depth   int
+	end     bool
+}
+
+func (s *statement) word(w, rest string) {
+	s.words++
+	afterEnd := s.end
+	s.end = w == "END"
+	if s.words == 1 {
+		switch w {
+		case "CREATE", "ALTER":
+			s.routine = true
+			return
+		case "IF", "WHILE", "BEGIN":
+			s.blocks = true
+		}
+	} else if s.routine && !s.blocks && s.words <= 8 {
+		switch w {
+		case "TRIGGER", "PROCEDURE", "FUNCTION", "EVENT":
+			s.blocks = true
+		}
+	}
+	if !s.blocks {
+		return
+	}
+	switch w {
+	case "BEGIN":
+		if !isTransaction(rest) {
+			s.depth++
+		}
+	case "CASE":
+		// END CASE closes the CASE statement of MySQL.
+		if !afterEnd {
+			s.depth++
+		}
+	case "END":
+		if s.depth > 0 {
+			s.depth--
+		}
+	case "IF", "LOOP", "WHILE", "REPEAT":
+		// END IF and the like close blocks that are not counted.
+		if afterEnd {
+			s.depth++
+		}
+	}
+}
+
+// isTransaction reports whether the BEGIN followed by rest starts a transaction rather than a block.
+func isTransaction(rest string) bool {
+	rest = strings.TrimLeft(rest, " \t\r\n")
+	if rest == "" || rest[0] == ';' {
+		return true
+	}
+	if !isWordStart(rest, 0) {
+		return false
+	}
+	switch strings.ToUpper(rest[:wordEnd(rest, 0)]) {
+	case "TRANSACTION", "TRAN", "WORK", "DEFERRED", "IMMEDIATE", "EXCLUSIVE", "DISTRIBUTED", "ISOLATION", "READ", "NOT":
+		return true
+	}
+	return false
+}
+
+// isWordStart reports whether a keyword or an unquoted identifier starts at s[i].
+func isWordStart(s string, i int) bool {
+	c := s[i]
+	if c != '_' && !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z') {
+		return false
+	}
+	return i == 0 || s[i-1] != '.' && !isWordChar(s[i-1])
+}
+
+func wordEnd(s string, i int) int {
+	for i++; i < len(s) && isWordChar(s[i]); i++ {
+	}
+	return i
+}
+
+func isWordChar(c byte) bool {
+	return c == '_' || c == '$' || '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
@@ -0,0 +1,54 @@
+package migrations
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		name     string
+		script   string
+		expected []string
+	}{
+		{name: "statements", script: "CREATE TABLE a (id int);\n\nINSERT INTO a VALUES (1);\n",
+			expected: []string{"CREATE TABLE a (id int)", "INSERT INTO a VALUES (1)"}},
+		{name: "no trailing semicolon", script: "SELECT 1; SELECT 2",
+			expected: []string{"SELECT 1", "SELECT 2"}},
+		{name: "empty statements", script: ";;\n -- only a comment\n;",
+			expected: nil},
+		{name: "line comments", script: "-- create a; drop b\nSELECT 1; -- trailing; comment\nSELECT 2;",
+			expected: []string{"SELECT 1", "SELECT 2"}},
+		{name: "block comments", script: "/* a; b */ SELECT 1; SELECT /* ; */ 2;",
+			expected: []string{"/* a; b */ SELECT 1", "SELECT /* ; */ 2"}},
+		{name: "quotes", script: `INSERT INTO a VALUES ('a;b', "c;d", ` + "`e;f`" + `); SELECT 1;`,
+			expected: []string{`INSERT INTO a VALUES ('a;b', "c;d", ` + "`e;f`" + `)`, "SELECT 1"}},
+		{name: "doubled quotes", script: `INSERT INTO a VALUES ('it''s;', "say ""hi;"""); SELECT 1;`,
+			expected: []string{`INSERT INTO a VALUES ('it''s;', "say ""hi;""")`, "SELECT 1"}},
+		{name: "dollar quotes", script: "CREATE FUNCTION f() RETURNS trigger AS $body$ BEGIN x := 1; RETURN NEW; END; $body$ LANGUAGE plpgsql;\nDO $$ BEGIN PERFORM 1; END $$;",
+			expected: []string{"CREATE FUNCTION f() RETURNS trigger AS $body$ BEGIN x := 1; RETURN NEW; END; $body$ LANGUAGE plpgsql", "DO $$ BEGIN PERFORM 1; END $$"}},
+		{name: "positional parameters", script: "PREPARE p AS SELECT $1; EXECUTE p(1);",
+			expected: []string{"PREPARE p AS SELECT $1", "EXECUTE p(1)"}},
+		{name: "sqlite trigger", script: "CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = n + 1; END;\nINSERT INTO a VALUES (1);",
+			expected: []string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = n + 1; END", "INSERT INTO a VALUES (1)"}},
+		{name: "case in a trigger", script: "CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = CASE WHEN n > 0 THEN 1 ELSE 0 END; DELETE FROM c; END; SELECT 1;",
+			expected: []string{"CREATE TRIGGER t AFTER INSERT ON a BEGIN UPDATE b SET n = CASE WHEN n > 0 THEN 1 ELSE 0 END; DELETE FROM c; END", "SELECT 1"}},
+		{name: "mysql procedure", script: "CREATE DEFINER=`root`@`%` PROCEDURE p(x int)\nBEGIN\n  IF x > 0 THEN\n    CASE x WHEN 1 THEN SELECT 1; ELSE SELECT 2; END CASE;\n  END IF;\n  label: LOOP\n    LEAVE label;\n  END LOOP label;\nEND;\nCALL p(1);",
+			expected: []string{"CREATE DEFINER=`root`@`%` PROCEDURE p(x int)\nBEGIN\n  IF x > 0 THEN\n    CASE x WHEN 1 THEN SELECT 1; ELSE SELECT 2; END CASE;\n  END IF;\n  label: LOOP\n    LEAVE label;\n  END LOOP label;\nEND", "CALL p(1)"}},
+		{name: "t-sql procedure", script: "CREATE OR ALTER PROCEDURE p AS\nBEGIN\n  BEGIN TRAN;\n  BEGIN TRY\n    UPDATE a SET n = 1;\n    COMMIT;\n  END TRY\n  BEGIN CATCH\n    ROLLBACK;\n  END CATCH\nEND;\nEXEC p;",
+			expected: []string{"CREATE OR ALTER PROCEDURE p AS\nBEGIN\n  BEGIN TRAN;\n  BEGIN TRY\n    UPDATE a SET n = 1;\n    COMMIT;\n  END TRY\n  BEGIN CATCH\n    ROLLBACK;\n  END CATCH\nEND", "EXEC p"}},
+		{name: "t-sql if block", script: "IF OBJECT_ID('a') IS NULL\nBEGIN\n  CREATE TABLE a (id int);\n  INSERT INTO a VALUES (1);\nEND;\nSELECT 1;",
+			expected: []string{"IF OBJECT_ID('a') IS NULL\nBEGIN\n  CREATE TABLE a (id int);\n  INSERT INTO a VALUES (1);\nEND", "SELECT 1"}},
+		{name: "transactions", script: "BEGIN;\nINSERT INTO a VALUES (1);\nEND;\nBEGIN TRANSACTION; SELECT 1; COMMIT;",
+			expected: []string{"BEGIN", "INSERT INTO a VALUES (1)", "END", "BEGIN TRANSACTION", "SELECT 1", "COMMIT"}},
+		{name: "keywords as names", script: "CREATE TABLE a (begin_at int, ends int, t_case int); SELECT a.begin, a.end FROM a; SELECT 1;",
+			expected: []string{"CREATE TABLE a (begin_at int, ends int, t_case int)", "SELECT a.begin, a.end FROM a", "SELECT 1"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := Split(tt.script); !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected=%q, actual=%q", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,133 @@
+package registry
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"sync"
+
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+)
+
+const Default = "default"
+
+var (
+	ErrNotFound  = errors.New("datasource not registered")
+	ErrDuplicate = errors.New("datasource already registered")
+)
+
+// Replica is implemented by *replicas.DB.
+type Replica interface {
+	DB() *gorm.DB
+	Close()
+}
+
+type Source struct {
+	Name    string
+	Primary *gorm.DB
+	Replica Replica
+	Options []*sql.TxOptions
+}
+
+var sources = struct {
+	mu sync.RWMutex
+	m  map[string]*Source
+}{m: make(map[string]*Source)}
+
+// Register adds a named datasource. Either primary or replica may be nil.
+func Register(name string, primary *gorm.DB, replica Replica, opts ...*sql.TxOptions) error {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	if _, ok := sources.m[name]; ok {
+		return errors.Wrap(ErrDuplicate, name)
+	}
+	sources.m[name] = &Source{
+		Name:    name,
+		Primary: primary,
+		Replica: replica,
+		Options: opts,
+	}
+	return nil
+}
+
+// SetPrimary registers or replaces the primary of name. transactions.Setup uses it for Default.
+func SetPrimary(name string, primary *gorm.DB, opts ...*sql.TxOptions) {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	src := &Source{Name: name, Primary: primary, Options: opts}
+	if v, ok := sources.m[name]; ok {
+		src.Replica = v.Replica
+	}
+	sources.m[name] = src
+}
+
+// SetReplica registers or replaces the replica of name. replicas.Setup uses it for Default.
+func SetReplica(name string, replica Replica) {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	src := &Source{Name: name, Replica: replica}
+	if v, ok := sources.m[name]; ok {
+		src.Primary, src.Options = v.Primary, v.Options
+	}
+	sources.m[name] = src
+}
+
+func Get(name string) (*Source, error) {
+	sources.mu.RLock()
+	defer sources.mu.RUnlock()
+	if v, ok := sources.m[name]; ok {
+		return v, nil
+	}
+	return nil, errors.Wrap(ErrNotFound, name)
+}
+
+func Names() []string {
+	sources.mu.RLock()
+	defer sources.mu.RUnlock()
+	names := make([]string, 0, len(sources.m))
+	for k := range sources.m {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Unregister removes the named datasource without closing it.
+func Unregister(name string) *Source {
+	sources.mu.Lock()
+	defer sources.mu.Unlock()
+	v := sources.m[name]
+	delete(sources.m, name)
+	return v
+}
+
+// CloseAll closes and removes every registered datasource.
+func CloseAll() {
+	sources.mu.Lock()
+	m := sources.m
+	sources.m = make(map[string]*Source)
+	sources.mu.Unlock()
+	for _, v := range m {
+		v.Close()
+	}
+}
+
+// Shutdown stops new transactions, waits for running ones until ctx is done and then closes every registered datasource.
+// The datasources are closed even if ctx expires first; the returned error reports it.
+// Only datasources in the registry are closed: a primary or replica that was opened but never registered
+// through Register, SetPrimary, SetReplica or the Setup functions has to be closed by its owner.
+func Shutdown(ctx context.Context) error {
+	err := foundations.Shutdown(ctx)
+	CloseAll()
+	return err
+}
+
+func (s *Source) Close() {
+	datasources.Close(s.Primary)
+	if s.Replica != nil {
+		s.Replica.Close()
+	}
+}
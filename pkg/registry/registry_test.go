@@ -0,0 +1,217 @@
+package registry_test
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/goccha/gormsource/pkg/registry"
+	"github.com/goccha/gormsource/pkg/replicas"
+	"github.com/goccha/gormsource/pkg/transactions"
+	"github.com/pkg/errors"
+	"gorm.io/gorm"
+	"gorm.io/gorm/utils/tests"
+)
+
+var errNotSupported = errors.New("not supported")
+
+// pool is a connection pool whose transactions report the pool they were begun on.
+type pool struct {
+	name string
+}
+
+func (p *pool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, errNotSupported
+}
+func (p *pool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return nil, errNotSupported
+}
+func (p *pool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return nil, errNotSupported
+}
+func (p *pool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return nil
+}
+func (p *pool) BeginTx(ctx context.Context, opts *sql.TxOptions) (gorm.ConnPool, error) {
+	return p, nil
+}
+func (p *pool) Commit() error {
+	return nil
+}
+func (p *pool) Rollback() error {
+	return nil
+}
+
+func open(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(tests.DummyDialector{}, &gorm.Config{ConnPool: &pool{name: name}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+type replica struct {
+	db     *gorm.DB
+	closed int
+}
+
+func (r *replica) DB() *gorm.DB {
+	return r.db
+}
+
+func (r *replica) Close() {
+	r.closed++
+}
+
+func poolName(db *gorm.DB) string {
+	if p, ok := db.Statement.ConnPool.(*pool); ok {
+		return p.name
+	}
+	return ""
+}
+
+func TestRegister(t *testing.T) {
+	t.Cleanup(registry.CloseAll)
+	primary := open(t, "billing")
+	if err := registry.Register("billing", primary, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := registry.Register("billing", open(t, "other"), nil); !errors.Is(err, registry.ErrDuplicate) {
+		t.Errorf("expected=%v, actual=%v", registry.ErrDuplicate, err)
+	}
+	src, err := registry.Get("billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Name != "billing" || src.Primary != primary {
+		t.Errorf("expected=billing, actual=%s", src.Name)
+	}
+	if _, err = registry.Get("unknown"); !errors.Is(err, registry.ErrNotFound) {
+		t.Errorf("expected=%v, actual=%v", registry.ErrNotFound, err)
+	}
+	if err = registry.Register("audit", nil, &replica{}); err != nil {
+		t.Fatal(err)
+	}
+	if expected, actual := "[audit billing]", fmt.Sprint(registry.Names()); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if src = registry.Unregister("audit"); src == nil || src.Name != "audit" {
+		t.Errorf("expected=audit, actual=%v", src)
+	}
+	if _, err = registry.Get("audit"); !errors.Is(err, registry.ErrNotFound) {
+		t.Errorf("expected=%v, actual=%v", registry.ErrNotFound, err)
+	}
+	if src = registry.Unregister("audit"); src != nil {
+		t.Errorf("expected=nil, actual=%v", src)
+	}
+}
+
+func TestSetPrimaryAndReplica(t *testing.T) {
+	t.Cleanup(registry.CloseAll)
+	r := &replica{}
+	registry.SetReplica("orders", r)
+	primary := open(t, "orders")
+	opt := &sql.TxOptions{Isolation: sql.LevelSerializable}
+	registry.SetPrimary("orders", primary, opt)
+	src, err := registry.Get("orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if src.Primary != primary || src.Replica != r || len(src.Options) != 1 {
+		t.Errorf("expected the replica to be kept when the primary is set, actual=%+v", src)
+	}
+	other := &replica{}
+	registry.SetReplica("orders", other)
+	if src, err = registry.Get("orders"); err != nil {
+		t.Fatal(err)
+	}
+	if src.Primary != primary || src.Replica != other || len(src.Options) != 1 || src.Options[0] != opt {
+		t.Errorf("expected the primary and options to be kept when the replica is set, actual=%+v", src)
+	}
+}
+
+func TestCloseAll(t *testing.T) {
+	a, b := &replica{}, &replica{}
+	registry.SetReplica("a", a)
+	registry.SetReplica("b", b)
+	registry.CloseAll()
+	if a.closed != 1 || b.closed != 1 {
+		t.Errorf("expected=1/1, actual=%d/%d", a.closed, b.closed)
+	}
+	if names := registry.Names(); len(names) != 0 {
+		t.Errorf("expected=[], actual=%v", names)
+	}
+}
+
+func TestUse(t *testing.T) {
+	t.Cleanup(registry.CloseAll)
+	ctx := context.Background()
+	members, err := replicas.New(func() (*gorm.DB, error) {
+		return open(t, "billing-replica"), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.Register("billing", open(t, "billing"), members); err != nil {
+		t.Fatal(err)
+	}
+	if err = registry.Register("audit", open(t, "audit"), nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"billing", "audit"} {
+		c, err := transactions.Use(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := transactions.Run(c, func(ctx context.Context, db *gorm.DB) (string, error) {
+			return poolName(db), nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name != actual {
+			t.Errorf("expected=%s, actual=%s", name, actual)
+		}
+	}
+	c, err := replicas.Use(ctx, "billing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actual, err := replicas.Run(c, func(ctx context.Context, db *gorm.DB) (string, error) {
+		return poolName(db), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "billing-replica"; expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+	if _, err = replicas.Use(ctx, "audit"); err == nil {
+		t.Errorf("expected an error for a datasource without replicas")
+	}
+	if _, err = transactions.Use(ctx, "unknown"); !errors.Is(err, registry.ErrNotFound) {
+		t.Errorf("expected=%v, actual=%v", registry.ErrNotFound, err)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	t.Cleanup(foundations.Resume)
+	registered, unregistered := &replica{}, &replica{}
+	registry.SetReplica("billing", registered)
+	if err := registry.Shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if registered.closed != 1 || unregistered.closed != 0 {
+		t.Errorf("expected=1/0, actual=%d/%d", registered.closed, unregistered.closed)
+	}
+	if names := registry.Names(); len(names) != 0 {
+		t.Errorf("expected=[], actual=%v", names)
+	}
+	if _, err := transactions.Run(context.Background(), func(ctx context.Context, db *gorm.DB) (string, error) {
+		return "", nil
+	}); !errors.Is(err, foundations.ErrShuttingDown) {
+		t.Errorf("expected=%v, actual=%v", foundations.ErrShuttingDown, err)
+	}
+}
@@ -0,0 +1,192 @@
+package reload
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/goccha/gormsource/pkg/datasources"
+	"github.com/goccha/gormsource/pkg/dialects"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/replicas"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Reloader applies configuration changes to running datasources.
+// Pool limits and Debug are changed in place. A changed replica list replaces the replicas through replicas.DB.Swap.
+// Other changes of the primary require a restart and are ignored.
+type Reloader struct {
+	// Interval is how often Watch checks the file.
+	Interval time.Duration
+	// DrainTimeout limits how long replaced replicas may keep connections in use before they are closed.
+	DrainTimeout time.Duration
+	Env          *datasources.Env
+	Options      []dialects.Option
+	mu           sync.Mutex
+	primary      *gorm.DB
+	replicas     *replicas.DB
+	dsns         []string
+}
+
+// New creates a Reloader for primary and replicas, either of which may be nil.
+// current are the configs of the running replicas; without them the first Apply replaces the replicas.
+// New must be called before the databases are shared between goroutines.
+func New(primary *gorm.DB, r *replicas.DB, current ...*datasources.Config) *Reloader {
+	if primary != nil {
+		logging.Level(primary)
+	}
+	if r != nil {
+		for _, m := range r.Members() {
+			logging.Level(m)
+		}
+	}
+	return &Reloader{
+		Interval:     5 * time.Second,
+		DrainTimeout: 30 * time.Second,
+		primary:      primary,
+		replicas:     r,
+		dsns:         dsns(current),
+	}
+}
+
+func dsns(configs []*datasources.Config) []string {
+	if len(configs) == 0 {
+		return nil
+	}
+	v := make([]string, 0, len(configs))
+	for _, c := range configs {
+		v = append(v, c.DSN())
+	}
+	return v
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func level(debug bool) logger.LogLevel {
+	if debug {
+		return logger.Info
+	}
+	return logger.Warn
+}
+
+func apply(ctx context.Context, db *gorm.DB, config *datasources.Config) error {
+	logging.Level(db).SetLevel(level(config.Debug))
+	return config.PoolConfig.Apply(ctx, db)
+}
+
+// Apply changes the datasources to primary and replicaConfigs. A nil primary or replicaConfigs is left unchanged.
+// An empty replicaConfigs is rejected by replicas.DB.Swap and keeps the current replicas.
+func (r *Reloader) Apply(ctx context.Context, primary *datasources.Config, replicaConfigs []*datasources.Config) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	errs := dialects.MultiError{}
+	if primary != nil && r.primary != nil {
+		errs.Add(apply(ctx, r.primary, primary))
+	}
+	if replicaConfigs != nil && r.replicas != nil {
+		members := r.replicas.Members()
+		if next := dsns(replicaConfigs); len(replicaConfigs) == len(members) && equal(next, r.dsns) {
+			for i, m := range members {
+				errs.Add(apply(ctx, m, replicaConfigs[i]))
+			}
+		} else {
+			errs.Add(r.swap(ctx, replicaConfigs, next))
+		}
+	}
+	return errs.Err()
+}
+
+func (r *Reloader) swap(ctx context.Context, configs []*datasources.Config, next []string) error {
+	connectors := make([]replicas.Connector, 0, len(configs))
+	for _, c := range configs {
+		connect := c.Connector(ctx)
+		connectors = append(connectors, func() (*gorm.DB, error) {
+			db, err := connect()
+			if err == nil {
+				logging.Level(db)
+			}
+			return db, err
+		})
+	}
+	if r.DrainTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.DrainTimeout)
+		defer cancel()
+	}
+	if err := r.replicas.Swap(ctx, connectors...); err != nil {
+		return err
+	}
+	r.dsns = next
+	logging.Info("replicas reloaded.")
+	return nil
+}
+
+// ApplyDocument applies the datasource and replicas of doc.
+func (r *Reloader) ApplyDocument(ctx context.Context, doc *datasources.Document) error {
+	primary, err := doc.Config(r.Env, r.Options...)
+	if err != nil {
+		return err
+	}
+	var configs []*datasources.Config
+	if len(doc.Replicas) > 0 {
+		if configs, err = doc.ReplicaConfigs(r.Env, r.Options...); err != nil {
+			return err
+		}
+	}
+	return r.Apply(ctx, primary, configs)
+}
+
+// Load reads the file at path and applies it.
+func (r *Reloader) Load(ctx context.Context, path string) error {
+	doc, err := datasources.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.ApplyDocument(ctx, doc)
+}
+
+// Watch applies the file at path whenever it changes until ctx is done. Errors are logged and the previous configuration is kept.
+func (r *Reloader) Watch(ctx context.Context, path string) error {
+	var modTime time.Time
+	var size int64
+	if info, err := os.Stat(path); err == nil {
+		modTime, size = info.ModTime(), info.Size()
+	}
+	interval := r.Interval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			logging.Warn("%v", err)
+			continue
+		}
+		if info.ModTime().Equal(modTime) && info.Size() == size {
+			continue
+		}
+		modTime, size = info.ModTime(), info.Size()
+		if err = r.Load(ctx, path); err != nil {
+			logging.Warn("reload %s: %v", path, err)
+		}
+	}
+}
@@ -3,11 +3,16 @@ package replicas
 import (
 	"context"
 	"database/sql"
+	"github.com/goccha/gormsource/pkg/datasources"
 	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/goccha/gormsource/pkg/registry"
+	"github.com/pkg/errors"
+	"strconv"
 	"sync"
 	"sync/atomic"
+	"time"
 
-	"github.com/goccha/envar/pkg/log"
+	"github.com/goccha/gormsource/pkg/logging"
 	"gorm.io/gorm"
 )
 
@@ -30,75 +35,226 @@ type Connector func() (*gorm.DB, error)
 
 var defaultReplica *DB
 
+type members struct {
+	dbs      []*gorm.DB
+	counter  *CyclicCounter
+	inFlight atomic.Int32
+}
+
+func newMembers(dbs []*gorm.DB) *members {
+	return &members{
+		dbs: dbs,
+		counter: &CyclicCounter{
+			mu:  &sync.RWMutex{},
+			max: int32(len(dbs)),
+			cnt: -1,
+		},
+	}
+}
+
+func (m *members) next() *gorm.DB {
+	return m.dbs[m.counter.next()]
+}
+
+func (m *members) begin(ctx context.Context, _ ...*sql.TxOptions) *gorm.DB {
+	return m.next().WithContext(ctx).Begin(replicaOption)
+}
+
+func (m *members) release() {
+	m.inFlight.Add(-1)
+}
+
 type DB struct {
-	dbs     []*gorm.DB
-	counter *CyclicCounter
+	current atomic.Pointer[members]
+	mu      sync.Mutex
+	plugins []gorm.Plugin
 }
 
 func (db *DB) DB() *gorm.DB {
-	return db.dbs[db.counter.next()]
+	return db.current.Load().next()
+}
+
+// acquire returns the current members with one more transaction in flight, so that Swap does not close them
+// until release is called.
+func (db *DB) acquire() *members {
+	for {
+		m := db.current.Load()
+		m.inFlight.Add(1)
+		if db.current.Load() == m {
+			return m
+		}
+		m.release()
+	}
+}
+
+// Members returns the current replicas in order.
+func (db *DB) Members() []*gorm.DB {
+	dbs := db.current.Load().dbs
+	return append(make([]*gorm.DB, 0, len(dbs)), dbs...)
 }
 
+// Use registers plugin on every replica, including the ones added later by Swap.
 func (db *DB) Use(plugin gorm.Plugin) error {
-	for _, v := range db.dbs {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, v := range db.current.Load().dbs {
 		if err := v.Use(plugin); err != nil {
 			return err
 		}
 	}
+	db.plugins = append(db.plugins, plugin)
 	return nil
 }
 
+// Swap opens new replicas with connectors and routes new transactions to them.
+// The previous replicas are closed once their transactions end or ctx is done.
+// When a connector fails, the new replicas are closed and the current ones are kept.
+func (db *DB) Swap(ctx context.Context, connectors ...Connector) error {
+	if len(connectors) == 0 {
+		return errors.New("no replicas")
+	}
+	dbs := make([]*gorm.DB, 0, len(connectors))
+	for _, c := range connectors {
+		d, err := c()
+		if err != nil {
+			closeAll(dbs)
+			return err
+		}
+		dbs = append(dbs, d)
+	}
+	db.mu.Lock()
+	for _, d := range dbs {
+		for _, p := range db.plugins {
+			if err := d.Use(p); err != nil {
+				db.mu.Unlock()
+				closeAll(dbs)
+				return err
+			}
+		}
+	}
+	old := db.current.Swap(newMembers(dbs))
+	db.mu.Unlock()
+	drain(ctx, old)
+	closeAll(old.dbs)
+	return nil
+}
+
+// drain waits until no transaction of m is in flight.
+func drain(ctx context.Context, m *members) {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := m.inFlight.Load()
+		if n <= 0 {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			logging.Warn("closing replicas with %d transaction(s) in flight: %v", n, ctx.Err())
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (db *DB) Close() {
-	for _, d := range db.dbs {
+	closeAll(db.current.Load().dbs)
+}
+
+func closeAll(dbs []*gorm.DB) {
+	for _, d := range dbs {
 		if sqlDB, err := d.DB(); err != nil {
-			log.Warn("%v", err)
+			logging.Warn("%v", err)
 		} else {
 			if err := sqlDB.Close(); err != nil {
-				log.Warn("%v", err)
+				logging.Warn("%v", err)
 			}
 		}
 	}
 }
 
+// Stats returns the pool statistics of every replica in order.
+func (db *DB) Stats() []sql.DBStats {
+	dbs := db.current.Load().dbs
+	stats := make([]sql.DBStats, 0, len(dbs))
+	for _, d := range dbs {
+		if sqlDB, err := d.DB(); err != nil {
+			stats = append(stats, sql.DBStats{})
+		} else {
+			stats = append(stats, sqlDB.Stats())
+		}
+	}
+	return stats
+}
+
+// Check pings every replica. The report is degraded while at least one replica is still up.
+func (db *DB) Check(ctx context.Context) datasources.HealthReport {
+	dbs := db.current.Load().dbs
+	results := make([]datasources.ConnectionHealth, len(dbs))
+	wg := &sync.WaitGroup{}
+	for i, d := range dbs {
+		wg.Add(1)
+		go func(i int, d *gorm.DB) {
+			defer wg.Done()
+			results[i] = datasources.CheckDB(ctx, "replica-"+strconv.Itoa(i), d)
+		}(i, d)
+	}
+	wg.Wait()
+	return datasources.NewHealthReport(results...)
+}
+
+// Setup calls New and registers the replicas as the default.
 func Setup(connectors ...Connector) (*DB, error) {
 	if db, err := New(connectors...); err != nil {
 		return nil, err
 	} else {
 		defaultReplica = db
+		registry.SetReplica(registry.Default, db)
 		return db, nil
 	}
 }
 
+// New opens a replica for every connector. Connectors created with datasources.Config.Connector apply the
+// pool limits and warmup of their Config, which Env.BuildReplicas and Document.ReplicaConfigs copy from the primary.
 func New(connectors ...Connector) (*DB, error) {
 	dbs := make([]*gorm.DB, 0)
 	for _, c := range connectors {
 		if db, err := c(); err != nil {
+			closeAll(dbs)
 			return nil, err
 		} else {
 			dbs = append(dbs, db)
 		}
 	}
-	return &DB{
-		dbs: dbs,
-		counter: &CyclicCounter{
-			mu:  &sync.RWMutex{},
-			max: int32(len(dbs)),
-			cnt: -1,
-		},
-	}, nil
+	db := &DB{}
+	db.current.Store(newMembers(dbs))
+	return db, nil
 }
 
-func getConnection(ctx context.Context) *gorm.DB {
+func getSource(ctx context.Context) *DB {
 	if v := ctx.Value(replicaSource); v != nil {
-		return v.(*DB).DB().WithContext(ctx)
+		return v.(*DB)
 	}
-	return defaultReplica.DB().WithContext(ctx)
+	return defaultReplica
 }
 
 func Begin(ctx context.Context, db *DB) context.Context {
 	return context.WithValue(ctx, replicaSource, db)
 }
 
+// Use selects the replicas registered under name for read-only transactions started with ctx.
+func Use(ctx context.Context, name string) (context.Context, error) {
+	src, err := registry.Get(name)
+	if err != nil {
+		return ctx, err
+	}
+	db, ok := src.Replica.(*DB)
+	if !ok || db == nil {
+		return ctx, errors.Errorf("%s: replica is not registered", name)
+	}
+	return Begin(logging.WithDatasource(ctx, name), db), nil
+}
+
 func With[T any](ctx context.Context, f func(ctx context.Context, db *gorm.DB) (T, error)) (T, error) {
 	if v := ctx.Value(withReadOnly); v != nil {
 		return f(ctx, v.(*foundations.TransactionContainer).DB)
@@ -121,21 +277,23 @@ func Run[T any](ctx context.Context, f func(ctx context.Context, db *gorm.DB) (T
 	if v := ctx.Value(withReadOnly); v != nil {
 		ctx = context.WithValue(ctx, withReadOnly, nil) // 新しいトランザクションをはじめる
 	}
-	return foundations.RunTransaction[T](ctx, begin, func(ctx context.Context, db *gorm.DB) (context.Context, T, error) {
+	m := getSource(ctx).acquire()
+	defer m.release()
+	return foundations.RunTransaction[T](ctx, m.begin, func(ctx context.Context, db *gorm.DB) (context.Context, T, error) {
 		ctx = context.WithValue(ctx, withReadOnly, &foundations.TransactionContainer{
 			DB:              db,
 			TransactionType: foundations.ReadOnly,
 		})
+		restore, err := datasources.ApplyTimeouts(ctx, db)
+		if err != nil {
+			return ctx, res, err
+		}
+		defer restore()
 		res, err = f(ctx, db)
 		return ctx, res, err
 	}, withReadOnly, opts...)
 }
 
-func begin(ctx context.Context, _ ...*sql.TxOptions) *gorm.DB {
-	db := getConnection(ctx)
-	return db.Begin(replicaOption)
-}
-
 type CyclicCounter struct {
 	mu  *sync.RWMutex
 	max int32
@@ -0,0 +1,179 @@
+package sqlcomment
+
+import (
+	"context"
+	"database/sql"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/goccha/gormsource/pkg/logging"
+	"gorm.io/gorm"
+)
+
+const name = "gormsource:sqlcomment"
+
+type contextKey struct {
+	key string
+}
+
+func (key contextKey) String() string {
+	return key.key
+}
+
+var (
+	routeKey       = contextKey{key: "route"}
+	traceparentKey = contextKey{key: "traceparent"}
+)
+
+// WithRoute records the endpoint that issues the statements, e.g. "GET /users/{id}".
+func WithRoute(ctx context.Context, route string) context.Context {
+	return context.WithValue(ctx, routeKey, route)
+}
+
+// WithTraceparent records a W3C traceparent header value.
+func WithTraceparent(ctx context.Context, traceparent string) context.Context {
+	return context.WithValue(ctx, traceparentKey, traceparent)
+}
+
+func value(ctx context.Context, key contextKey) string {
+	if v, ok := ctx.Value(key).(string); ok {
+		return v
+	}
+	return ""
+}
+
+// Plugin appends a sqlcommenter comment such as /*request_id='abc',route='GET%20%2Fusers'*/ to every statement.
+// The values are taken from the context of the statement.
+type Plugin struct {
+	// Application is added as application when set.
+	Application string
+	// Tags adds application specific values of the context.
+	Tags func(ctx context.Context) map[string]string
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return name
+}
+
+// Initialize wraps only the callback that executes the statement, so that transactions begun by gorm are not affected.
+// Without Before, gorm would sort after behind gorm:commit_or_rollback_transaction, which then cannot find the transaction.
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register(name+":before_create", p.before),
+		cb.Create().After("gorm:create").Before("gorm:save_after_associations").Register(name+":after_create", after),
+		cb.Query().Before("gorm:query").Register(name+":before_query", p.before),
+		cb.Query().After("gorm:query").Before("gorm:preload").Register(name+":after_query", after),
+		cb.Update().Before("gorm:update").Register(name+":before_update", p.before),
+		cb.Update().After("gorm:update").Before("gorm:save_after_associations").Register(name+":after_update", after),
+		cb.Delete().Before("gorm:delete").Register(name+":before_delete", p.before),
+		cb.Delete().After("gorm:delete").Before("gorm:after_delete").Register(name+":after_delete", after),
+		cb.Row().Before("gorm:row").Register(name+":before_row", p.before),
+		cb.Row().After("gorm:row").Register(name+":after_row", after),
+		cb.Raw().Before("gorm:raw").Register(name+":before_raw", p.before),
+		cb.Raw().After("gorm:raw").Register(name+":after_raw", after),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// tags returns the values added to the statements run with ctx.
+func (p *Plugin) tags(ctx context.Context) map[string]string {
+	tags := make(map[string]string)
+	add := func(k, v string) {
+		if v != "" {
+			tags[k] = v
+		}
+	}
+	add("application", p.Application)
+	add("route", value(ctx, routeKey))
+	add("request_id", logging.RequestID(ctx))
+	add("traceparent", value(ctx, traceparentKey))
+	add("tx_type", foundations.TransactionType(ctx))
+	add("datasource", logging.Datasource(ctx))
+	if p.Tags != nil {
+		for k, v := range p.Tags(ctx) {
+			add(k, v)
+		}
+	}
+	return tags
+}
+
+// Format builds a comment in the sqlcommenter format. Keys are sorted, and keys and values are url encoded,
+// which also escapes quotes and "*/".
+func Format(tags map[string]string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	buf := &strings.Builder{}
+	buf.WriteString("/*")
+	for i, k := range keys {
+		if i > 0 {
+			buf.WriteString(",")
+		}
+		buf.WriteString(url.PathEscape(k))
+		buf.WriteString("='")
+		buf.WriteString(url.PathEscape(tags[k]))
+		buf.WriteString("'")
+	}
+	buf.WriteString("*/")
+	return buf.String()
+}
+
+func (p *Plugin) before(db *gorm.DB) {
+	if db.Statement == nil || db.Statement.Context == nil {
+		return
+	}
+	if _, ok := db.Statement.ConnPool.(*connPool); ok {
+		return
+	}
+	if comment := Format(p.tags(db.Statement.Context)); comment != "" {
+		db.Statement.ConnPool = &connPool{ConnPool: db.Statement.ConnPool, comment: " " + comment}
+	}
+}
+
+// after restores the pool so that the comment of one statement never leaks into the next.
+func after(db *gorm.DB) {
+	if db.Statement == nil {
+		return
+	}
+	if p, ok := db.Statement.ConnPool.(*connPool); ok {
+		db.Statement.ConnPool = p.ConnPool
+	}
+}
+
+type connPool struct {
+	gorm.ConnPool
+	comment string
+}
+
+func (p *connPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return p.ConnPool.PrepareContext(ctx, query+p.comment)
+}
+
+func (p *connPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return p.ConnPool.ExecContext(ctx, query+p.comment, args...)
+}
+
+func (p *connPool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	return p.ConnPool.QueryContext(ctx, query+p.comment, args...)
+}
+
+func (p *connPool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	return p.ConnPool.QueryRowContext(ctx, query+p.comment, args...)
+}
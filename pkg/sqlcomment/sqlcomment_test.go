@@ -0,0 +1,46 @@
+package sqlcomment
+
+import (
+	"context"
+	"testing"
+
+	"github.com/goccha/gormsource/pkg/logging"
+)
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		name     string
+		tags     map[string]string
+		expected string
+	}{
+		{name: "empty", tags: map[string]string{}, expected: ""},
+		{name: "sorted", tags: map[string]string{"route": "/users", "application": "api"},
+			expected: "/*application='api',route='%2Fusers'*/"},
+		{name: "spaces", tags: map[string]string{"route": "GET /users/{id}"},
+			expected: "/*route='GET%20%2Fusers%2F%7Bid%7D'*/"},
+		{name: "quotes", tags: map[string]string{"it's": "a'b\"c"},
+			expected: "/*it%27s='a%27b%22c'*/"},
+		{name: "comment end", tags: map[string]string{"k": "*/ DROP TABLE users; /*"},
+			expected: "/*k='%2A%2F%20DROP%20TABLE%20users%3B%20%2F%2A'*/"},
+		{name: "traceparent", tags: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+			expected: "/*traceparent='00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01'*/"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if actual := Format(tt.tags); tt.expected != actual {
+				t.Errorf("expected=%s, actual=%s", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestTags(t *testing.T) {
+	p := &Plugin{Application: "api", Tags: func(ctx context.Context) map[string]string {
+		return map[string]string{"tenant": "acme", "empty": ""}
+	}}
+	ctx := WithTraceparent(WithRoute(logging.WithDatasource(context.Background(), "billing"), "GET /users"), "00-1-2-01")
+	expected := "/*application='api',datasource='billing',route='GET%20%2Fusers',tenant='acme',traceparent='00-1-2-01'*/"
+	if actual := Format(p.tags(ctx)); expected != actual {
+		t.Errorf("expected=%s, actual=%s", expected, actual)
+	}
+}
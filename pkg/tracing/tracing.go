@@ -0,0 +1,215 @@
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/gorm"
+)
+
+const (
+	name                = "gormsource:tracing"
+	instrumentationName = "github.com/goccha/gormsource/pkg/tracing"
+	spanKey             = name + ":span"
+)
+
+const (
+	TransactionTypeKey      = attribute.Key("gormsource.transaction.type")
+	TransactionIsolationKey = attribute.Key("gormsource.transaction.isolation")
+	TransactionReadOnlyKey  = attribute.Key("gormsource.transaction.read_only")
+	TransactionOutcomeKey   = attribute.Key("gormsource.transaction.outcome")
+	TransactionHooksKey     = attribute.Key("gormsource.transaction.hooks")
+	DatasourceKey           = attribute.Key("gormsource.datasource")
+	RowsAffectedKey         = attribute.Key("gormsource.rows_affected")
+)
+
+func tracer(provider trace.TracerProvider) trace.Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	return provider.Tracer(instrumentationName)
+}
+
+// TraceTransactions starts a span for every foundations.RunTransaction. The spans of the statements
+// run in the transaction become its children. A nil provider uses the global provider.
+// Tracing stops when the returned remove is called.
+func TraceTransactions(provider trace.TracerProvider) (remove func()) {
+	return foundations.OnStart(func(ctx context.Context, info foundations.TransactionInfo) (context.Context, foundations.TransactionEnd) {
+		attrs := []attribute.KeyValue{TransactionTypeKey.String(info.Type)}
+		if info.Options != nil {
+			attrs = append(attrs,
+				TransactionIsolationKey.String(info.Options.Isolation.String()),
+				TransactionReadOnlyKey.Bool(info.Options.ReadOnly))
+		}
+		if ds := logging.Datasource(ctx); ds != "" {
+			attrs = append(attrs, DatasourceKey.String(ds))
+		}
+		ctx, span := tracer(provider).Start(ctx, "transaction "+info.Type,
+			trace.WithSpanKind(trace.SpanKindInternal), trace.WithAttributes(attrs...))
+		return ctx, func(_ context.Context, outcome foundations.Outcome, hooks int, err error) {
+			span.SetAttributes(TransactionOutcomeKey.String(string(outcome)), TransactionHooksKey.Int(hooks))
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}
+	})
+}
+
+// Plugin starts a client span for every statement following the OpenTelemetry database semantic conventions.
+// Use it before sqlcomment.Plugin, so that Traceparent sees the span of the statement.
+type Plugin struct {
+	TracerProvider trace.TracerProvider
+	// System is recorded as db.system. It is derived from the name of the dialector when empty.
+	System string
+	// Namespace is recorded as db.namespace when set.
+	Namespace string
+	// DisableQueryText omits db.query.text. The text never contains the values of the parameters.
+	DisableQueryText bool
+	tracer           trace.Tracer
+}
+
+func New() *Plugin {
+	return &Plugin{}
+}
+
+func (p *Plugin) Name() string {
+	return name
+}
+
+func (p *Plugin) Initialize(db *gorm.DB) error {
+	p.tracer = tracer(p.TracerProvider)
+	if p.System == "" {
+		p.System = system(db.Dialector.Name())
+	}
+	cb := db.Callback()
+	errs := []error{
+		cb.Create().Before("gorm:create").Register(name+":before_create", p.before("INSERT")),
+		cb.Create().After("gorm:create").Register(name+":after_create", p.after),
+		cb.Query().Before("gorm:query").Register(name+":before_query", p.before("SELECT")),
+		cb.Query().After("gorm:query").Register(name+":after_query", p.after),
+		cb.Update().Before("gorm:update").Register(name+":before_update", p.before("UPDATE")),
+		cb.Update().After("gorm:update").Register(name+":after_update", p.after),
+		cb.Delete().Before("gorm:delete").Register(name+":before_delete", p.before("DELETE")),
+		cb.Delete().After("gorm:delete").Register(name+":after_delete", p.after),
+		cb.Row().Before("gorm:row").Register(name+":before_row", p.before("")),
+		cb.Row().After("gorm:row").Register(name+":after_row", p.after),
+		cb.Raw().Before("gorm:raw").Register(name+":before_raw", p.before("")),
+		cb.Raw().After("gorm:raw").Register(name+":after_raw", p.after),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// system maps the name of a dialector to db.system.
+func system(dialector string) string {
+	switch dialector {
+	case "postgres":
+		return "postgresql"
+	case "sqlserver":
+		return "mssql"
+	}
+	return dialector
+}
+
+type statementSpan struct {
+	span      trace.Span
+	ctx       context.Context
+	operation string
+}
+
+func (p *Plugin) before(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		if db.Statement == nil || db.Statement.Context == nil {
+			return
+		}
+		attrs := []attribute.KeyValue{semconv.DBSystemKey.String(p.System)}
+		if p.Namespace != "" {
+			attrs = append(attrs, semconv.DBNamespace(p.Namespace))
+		}
+		if ds := logging.Datasource(db.Statement.Context); ds != "" {
+			attrs = append(attrs, DatasourceKey.String(ds))
+		}
+		ctx, span := p.tracer.Start(db.Statement.Context, p.System,
+			trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+		db.InstanceSet(spanKey, &statementSpan{span: span, ctx: db.Statement.Context, operation: operation})
+		db.Statement.Context = ctx
+	}
+}
+
+func (p *Plugin) after(db *gorm.DB) {
+	if db.Statement == nil {
+		return
+	}
+	v, ok := db.InstanceGet(spanKey)
+	if !ok {
+		return
+	}
+	s := v.(*statementSpan)
+	db.Statement.Context = s.ctx
+	query := db.Statement.SQL.String()
+	operation := s.operation
+	if operation == "" {
+		operation = operationOf(query)
+	}
+	spanName := p.System
+	if operation != "" {
+		s.span.SetAttributes(semconv.DBOperationName(operation))
+		spanName = operation
+	}
+	if table := db.Statement.Table; table != "" {
+		s.span.SetAttributes(semconv.DBCollectionName(table))
+		if operation != "" {
+			spanName = operation + " " + table
+		}
+	}
+	s.span.SetName(spanName)
+	if query != "" && !p.DisableQueryText {
+		s.span.SetAttributes(semconv.DBQueryText(query))
+	}
+	s.span.SetAttributes(RowsAffectedKey.Int64(db.RowsAffected))
+	if db.Error != nil && !errors.Is(db.Error, gorm.ErrRecordNotFound) {
+		s.span.RecordError(db.Error)
+		s.span.SetStatus(codes.Error, db.Error.Error())
+	}
+	s.span.End()
+}
+
+// operationOf returns the first keyword of query, e.g. SELECT.
+func operationOf(query string) string {
+	fields := strings.Fields(query)
+	if len(fields) == 0 {
+		return ""
+	}
+	return strings.ToUpper(fields[0])
+}
+
+// Traceparent returns the W3C traceparent of the span in ctx, or "" when ctx has no valid span.
+func Traceparent(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		return ""
+	}
+	return "00-" + sc.TraceID().String() + "-" + sc.SpanID().String() + "-" + sc.TraceFlags().String()
+}
+
+// Tags can be set to sqlcomment.Plugin.Tags to add the traceparent of the statement span.
+func Tags(ctx context.Context) map[string]string {
+	if tp := Traceparent(ctx); tp != "" {
+		return map[string]string{"traceparent": tp}
+	}
+	return nil
+}
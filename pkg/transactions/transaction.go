@@ -3,7 +3,11 @@ package transactions
 import (
 	"context"
 	"database/sql"
+	"github.com/goccha/gormsource/pkg/datasources"
 	"github.com/goccha/gormsource/pkg/foundations"
+	"github.com/goccha/gormsource/pkg/logging"
+	"github.com/goccha/gormsource/pkg/registry"
+	"github.com/pkg/errors"
 
 	"gorm.io/gorm"
 )
@@ -32,6 +36,7 @@ func Setup(conn func() (*gorm.DB, error), opt ...*sql.TxOptions) (*gorm.DB, erro
 	} else {
 		defaultDB = db
 		defaultOptions = opt
+		registry.SetPrimary(registry.Default, db, opt...)
 		return defaultDB, nil
 	}
 }
@@ -57,6 +62,18 @@ func Begin(ctx context.Context, db *gorm.DB, opts ...*sql.TxOptions) context.Con
 	})
 }
 
+// Use selects the primary registered under name for transactions started with ctx.
+func Use(ctx context.Context, name string) (context.Context, error) {
+	src, err := registry.Get(name)
+	if err != nil {
+		return ctx, err
+	}
+	if src.Primary == nil {
+		return ctx, errors.Errorf("%s: primary is not registered", name)
+	}
+	return Begin(logging.WithDatasource(ctx, name), src.Primary, src.Options...), nil
+}
+
 func With[T any](ctx context.Context, f func(ctx context.Context, db *gorm.DB) (T, error), opts ...*sql.TxOptions) (T, error) {
 	if v := ctx.Value(foundations.WithTransaction()); foundations.IsActive(v) {
 		return f(ctx, v.(*foundations.TransactionContainer).DB)
@@ -74,6 +91,11 @@ func Run[T any](ctx context.Context, txFunc func(ctx context.Context, db *gorm.D
 			DB:              db,
 			TransactionType: foundations.Transaction,
 		})
+		restore, err := datasources.ApplyTimeouts(ctx, db)
+		if err != nil {
+			return ctx, res, err
+		}
+		defer restore()
 		res, err = txFunc(ctx, db)
 		return ctx, res, err
 	}, foundations.WithTransaction(), opts...)
@@ -84,6 +106,9 @@ func begin(ctx context.Context, opts ...*sql.TxOptions) *gorm.DB {
 	if len(opts) > 0 {
 		return db.Begin(opts...)
 	}
+	if v := ctx.Value(transactionSource); v != nil && len(v.(*transactionOption).options) > 0 {
+		return db.Begin(v.(*transactionOption).options...)
+	}
 	return db.Begin(defaultOptions...)
 }
 